@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// diffContext is number of unchanged lines around changes
+const diffContext = 3
+
+// writeDiff writes unified diff of input and output indented by two spaces
+func writeDiff(w io.Writer, input, output []byte) error {
+	a, err := indentedLines(input)
+	if err != nil {
+		return fmt.Errorf("unable to indent input: %w", err)
+	}
+	b, err := indentedLines(output)
+	if err != nil {
+		return fmt.Errorf("unable to indent output: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("--- input\n+++ output\n")
+	for _, h := range diffHunks(diffLines(a, b)) {
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", h.aStart+1, h.aCount, h.bStart+1, h.bCount)
+		for _, l := range h.lines {
+			buf.WriteByte(l.op)
+			buf.WriteString(l.text)
+			buf.WriteByte('\n')
+		}
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func indentedLines(data []byte) ([]string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return nil, err
+	}
+	return strings.Split(buf.String(), "\n"), nil
+}
+
+// diffLine is line of diff, op is ' ' for unchanged line, '-' for removed and '+' for added one
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines returns the shortest edit script of a to b.
+//
+// It's found by Myers' algorithm in linear space: the middle snake of the edit path
+// splits a and b into smaller parts diffed recursively.
+func diffLines(a, b []string) []diffLine {
+	n := (len(a)+len(b)+1)/2 + 1 // max number of edits of the middle snake search plus one
+	d := lineDiff{
+		a:        a,
+		b:        b,
+		lines:    make([]diffLine, 0, max(len(a), len(b))),
+		forward:  make([]int, 2*n+1),
+		backward: make([]int, 2*n+1),
+		offset:   n,
+	}
+	d.diff(0, len(a), 0, len(b))
+
+	// lines removed by a change are written before added ones
+	for i := 0; i < len(d.lines); i++ {
+		j := i
+		for j < len(d.lines) && d.lines[j].op != ' ' {
+			j++
+		}
+		change := d.lines[i:j]
+		sort.SliceStable(change, func(x, y int) bool { return change[x].op == '-' && change[y].op == '+' })
+		i = j
+	}
+	return d.lines
+}
+
+// lineDiff holds state of diffLines, forward and backward are the furthest reaching paths per diagonal
+type lineDiff struct {
+	a, b              []string
+	lines             []diffLine
+	forward, backward []int
+	offset            int // index of diagonal 0
+}
+
+// diff appends edit script of a[a0:a1] to b[b0:b1]
+func (d *lineDiff) diff(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.lines = append(d.lines, diffLine{op: ' ', text: d.a[a0]})
+		a0++
+		b0++
+	}
+	suffix := a1
+	for a1 > a0 && b1 > b0 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+	}
+	switch {
+	case a0 == a1:
+		for _, text := range d.b[b0:b1] {
+			d.lines = append(d.lines, diffLine{op: '+', text: text})
+		}
+	case b0 == b1:
+		for _, text := range d.a[a0:a1] {
+			d.lines = append(d.lines, diffLine{op: '-', text: text})
+		}
+	default:
+		// a and b differ at both ends, so the edit path has at least 2 edits
+		// and both its halves around the middle snake are shorter
+		x, y, u, v := d.middleSnake(a0, a1, b0, b1)
+		d.diff(a0, x, b0, y)
+		for _, text := range d.a[x:u] {
+			d.lines = append(d.lines, diffLine{op: ' ', text: text})
+		}
+		d.diff(u, a1, v, b1)
+	}
+	for _, text := range d.a[a1:suffix] {
+		d.lines = append(d.lines, diffLine{op: ' ', text: text})
+	}
+}
+
+// middleSnake returns start (x, y) and end (u, v) of the middle snake of the shortest edit path
+// of a[a0:a1] to b[b0:b1], it's found by forward and backward searches meeting each other.
+// Diagonal k contains points with x-y == k relative to (a0, b0).
+func (d *lineDiff) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	forward, backward := d.forward, d.backward
+	off := d.offset
+	forward[off+1] = 0  // the forward path starts at (0, 0)
+	backward[off-1] = n // the backward path starts at (n, m), its diagonals are relative to delta
+	for e := 0; ; e++ {
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && forward[off+k-1] < forward[off+k+1]) {
+				x = forward[off+k+1] // insertion
+			} else {
+				x = forward[off+k-1] + 1 // deletion
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			forward[off+k] = x
+			if c := k - delta; odd && c >= -(e-1) && c <= e-1 && backward[off+c] <= x {
+				return a0 + startX, b0 + startY, a0 + x, b0 + y
+			}
+		}
+		for c := -e; c <= e; c += 2 {
+			var x int
+			if c == e || (c != -e && backward[off+c-1] < backward[off+c+1]) {
+				x = backward[off+c-1] // insertion
+			} else {
+				x = backward[off+c+1] - 1 // deletion
+			}
+			k := c + delta
+			y := x - k
+			endX, endY := x, y
+			for x > 0 && y > 0 && d.a[a0+x-1] == d.b[b0+y-1] {
+				x--
+				y--
+			}
+			backward[off+c] = x
+			if !odd && k >= -e && k <= e && forward[off+k] >= x {
+				return a0 + x, b0 + y, a0 + endX, b0 + endY
+			}
+		}
+	}
+}
+
+// diffHunk is group of changed lines with their context
+type diffHunk struct {
+	aStart, aCount int
+	bStart, bCount int
+	lines          []diffLine
+}
+
+// diffHunks groups changed lines with their context, hunks with overlapping contexts are merged
+func diffHunks(lines []diffLine) []diffHunk {
+	var ranges [][2]int // ranges of lines included into hunks
+	for i, l := range lines {
+		if l.op == ' ' {
+			continue
+		}
+		from, to := max(i-diffContext, 0), min(i+diffContext+1, len(lines))
+		if n := len(ranges); n > 0 && from <= ranges[n-1][1] {
+			ranges[n-1][1] = to
+		} else {
+			ranges = append(ranges, [2]int{from, to})
+		}
+	}
+
+	hunks := make([]diffHunk, 0, len(ranges))
+	var aPos, bPos, i int // line numbers of a and b before lines[i]
+	advance := func(l diffLine) {
+		if l.op != '+' {
+			aPos++
+		}
+		if l.op != '-' {
+			bPos++
+		}
+	}
+	for _, r := range ranges {
+		for ; i < r[0]; i++ {
+			advance(lines[i])
+		}
+		h := diffHunk{aStart: aPos, bStart: bPos}
+		for ; i < r[1]; i++ {
+			h.add(lines[i])
+			advance(lines[i])
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+func (h *diffHunk) add(l diffLine) {
+	h.lines = append(h.lines, l)
+	if l.op != '+' {
+		h.aCount++
+	}
+	if l.op != '-' {
+		h.bCount++
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyberstudio/jsonj"
+	"github.com/cyberstudio/jsonj/confj"
+)
+
+// newRegistry returns registry of built-in generators and factories,
+// relative paths of generator definitions are resolved against dir
+func newRegistry(dir string) *confj.GeneratorRegistry {
+	registry := confj.NewGeneratorRegistry()
+	registry.Register("empty", jsonj.EmptyFragmentsGenerator)
+	registry.Register("null", jsonj.Null())
+	registry.RegisterFactory("lookup", func(decode func(v interface{}) error) (jsonj.GenerateFragmentBatchFunc, error) {
+		return newLookupGenerator(dir, decode)
+	})
+	registry.RegisterFactory("template", newTemplateGenerator)
+	registry.RegisterFactory("constant", newConstantGenerator)
+	return registry
+}
+
+// lookupSpec defines generator looking up mark values in static table
+type lookupSpec struct {
+	// File is CSV file of key and value columns or JSON object file
+	File string `yaml:"file"`
+	// Missing determines value of keys missing in table: null (by default), keep, empty or error
+	Missing string `yaml:"missing"`
+	// Field wraps value into object with the field, i.e. to be used by Insert mode
+	Field string `yaml:"field"`
+}
+
+var missingPolicies = map[string]jsonj.MissingPolicy{
+	"":      jsonj.MissingNull,
+	"null":  jsonj.MissingNull,
+	"keep":  jsonj.MissingKeep,
+	"empty": jsonj.MissingEmpty,
+	"error": jsonj.MissingError,
+}
+
+func newLookupGenerator(dir string, decode func(v interface{}) error) (jsonj.GenerateFragmentBatchFunc, error) {
+	var spec lookupSpec
+	if err := decode(&spec); err != nil {
+		return nil, err
+	}
+	if spec.File == "" {
+		return nil, errors.New("file is missing")
+	}
+	missing, ok := missingPolicies[spec.Missing]
+	if !ok {
+		return nil, fmt.Errorf("unknown missing policy '%s', expected one of: null, keep, empty, error", spec.Missing)
+	}
+	path := spec.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	table, err := readTable(path)
+	if err != nil {
+		return nil, err
+	}
+	return withField(spec.Field, jsonj.MapLookup(table, missing)), nil
+}
+
+// readTable reads CSV file of key and value columns or JSON object file
+func readTable(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[string]interface{})
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &table); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", path, err)
+		}
+		return table, nil
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = 2
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	for _, record := range records {
+		table[record[0]] = record[1]
+	}
+	return table, nil
+}
+
+// templateSpec defines generator rendering string by template, see jsonj.URLTemplate
+type templateSpec struct {
+	// Template contains placeholders {value} replaced by path-escaped mark value and {name} replaced by params field
+	Template string `yaml:"template"`
+	// Field wraps value into object with the field, i.e. to be used by Insert mode
+	Field string `yaml:"field"`
+}
+
+func newTemplateGenerator(decode func(v interface{}) error) (jsonj.GenerateFragmentBatchFunc, error) {
+	var spec templateSpec
+	if err := decode(&spec); err != nil {
+		return nil, err
+	}
+	if spec.Template == "" {
+		return nil, errors.New("template is missing")
+	}
+	for _, part := range strings.Split(spec.Template, "{")[1:] {
+		if !strings.Contains(part, "}") {
+			return nil, errors.New("template has unclosed placeholder")
+		}
+	}
+	return withField(spec.Field, jsonj.URLTemplate(spec.Template)), nil
+}
+
+// constantSpec defines generator of the same value for every mark
+type constantSpec struct {
+	Value interface{} `yaml:"value"`
+	// Field wraps value into object with the field, i.e. to be used by Insert mode
+	Field string `yaml:"field"`
+}
+
+func newConstantGenerator(decode func(v interface{}) error) (jsonj.GenerateFragmentBatchFunc, error) {
+	var spec constantSpec
+	if err := decode(&spec); err != nil {
+		return nil, err
+	}
+	return withField(spec.Field, jsonj.Constant(spec.Value)), nil
+}
+
+// withField wraps fragments of batchFunc into objects with the field if field isn't empty,
+// empty fragments (struct{}) aren't wrapped
+func withField(field string, batchFunc jsonj.GenerateFragmentBatchFunc) jsonj.GenerateFragmentBatchFunc {
+	if field == "" {
+		return batchFunc
+	}
+	return func(ctx context.Context, iterator jsonj.FragmentIterator, p interface{}) ([]interface{}, error) {
+		result, err := batchFunc(ctx, iterator, p)
+		if err != nil {
+			return nil, err
+		}
+		for i, fragment := range result {
+			if _, empty := fragment.(struct{}); !empty {
+				result[i] = map[string]interface{}{field: fragment}
+			}
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,185 @@
+// Command jsonj applies passes described by configuration file to JSON data.
+//
+// Usage:
+//
+//	jsonj -config passes.yaml [-params params.json] [-dry-run | -diff] [-trace] [input.json]
+//
+// JSON data is read from input file or stdin, output is written to stdout.
+// Configuration format is described in package confj, it may define generators of the following types:
+//
+//	lookup:   value is looked up in static table, see lookupSpec
+//	template: value is rendered by template, see templateSpec
+//	constant: the same value for every mark, see constantSpec
+//
+// Built-in generators "empty" (jsonj.EmptyFragmentsGenerator) and "null" are available without definition.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cyberstudio/jsonj"
+	"github.com/cyberstudio/jsonj/confj"
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(os.Stderr, "jsonj:", err)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "jsonj:", err)
+		os.Exit(1)
+	}
+}
+
+// usageError is error of command line arguments, the command exits with code 2 like flag.ExitOnError does
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("jsonj", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "passes configuration file, JSON or YAML (required)")
+	paramsPath := fs.String("params", "", "JSON object file passed to generators as params")
+	dryRun := fs.Bool("dry-run", false,
+		"list marks rewritten by every pass repeat instead of output, generators are called as usual")
+	diff := fs.Bool("diff", false, "write difference between indented input and output instead of output")
+	trace := fs.Bool("trace", false, "write rewrites of every pass repeat to stderr")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(),
+			"Usage: jsonj -config passes.yaml [-params params.json] [-dry-run | -diff] [-trace] [input.json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError(err.Error()) // reported by fs
+	}
+	if *configPath == "" {
+		fs.Usage()
+		return usageError("config is missing")
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return usageError("too many input files")
+	}
+	if *dryRun && *diff {
+		fs.Usage()
+		return usageError("-dry-run and -diff are mutually exclusive")
+	}
+
+	passes, err := confj.LoadFile(*configPath, newRegistry(filepath.Dir(*configPath)))
+	if err != nil {
+		return err
+	}
+	params := jsonj.ProcessParams{Passes: passes}
+	if *paramsPath != "" {
+		if params.Params, err = readParams(*paramsPath); err != nil {
+			return err
+		}
+	}
+	input, err := readInput(fs.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+
+	if *trace || *dryRun {
+		params.Trace = &jsonj.Trace{}
+	}
+	output, err := jsonj.Process(context.Background(), input, params)
+	if *trace {
+		if traceErr := params.Trace.WriteText(stderr); traceErr != nil && err == nil {
+			err = traceErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return writeMatches(stdout, params.Trace)
+	}
+	if *diff {
+		return writeDiff(stdout, input, output)
+	}
+	_, err = stdout.Write(output)
+	return err
+}
+
+func readInput(path string, stdin io.Reader) ([]byte, error) {
+	var (
+		input []byte
+		err   error
+	)
+	if path == "" || path == "-" {
+		input, err = io.ReadAll(stdin)
+	} else {
+		input, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input: %w", err)
+	}
+	if !json.Valid(input) {
+		return nil, errors.New("input is not a valid json")
+	}
+	return input, nil
+}
+
+func readParams(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read params: %w", err)
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("unable to read params %s: %w", path, err)
+	}
+	return params, nil
+}
+
+// writeMatches writes marks and elements rewritten by every pass repeat of trace
+func writeMatches(w io.Writer, trace *jsonj.Trace) error {
+	for _, r := range trace.Repeats {
+		fmt.Fprintf(w, "pass %d, repeat %d:\n", r.Pass, r.Repeat)
+		if len(r.Rewrites) == 0 {
+			fmt.Fprintln(w, "  no marks found")
+		}
+		for _, rewrite := range r.Rewrites {
+			value := compactValue(rewrite.Value)
+			if _, err := fmt.Fprintf(w, "  %s at %d: %s\n", rewrite.Rule, rewrite.Start, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compactValue returns json value without insignificant whitespaces, long values are truncated
+func compactValue(value []byte) []byte {
+	const maxLen = 60
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, value); err != nil {
+		return value
+	}
+	if buf.Len() > maxLen {
+		buf.Truncate(maxLen - 3)
+		buf.WriteString("...")
+	}
+	return buf.Bytes()
+}
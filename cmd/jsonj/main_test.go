@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "uuids.csv", "1,74ea3f44-ba35-4d2d-8a3e-01fb4c458df4\n9,fe4188f6-1993-4cce-8726-34294bfd1f1b\n")
+	writeFile(t, dir, "families.json", `{"9": {"name": "Cat"}}`)
+	writeFile(t, dir, "params.json", `{"base_url": "https://zoo.com"}`)
+	writeFile(t, dir, "passes.yaml", `
+generators:
+  petUUID:
+    type: lookup
+    file: uuids.csv
+  petURL:
+    type: template
+    template: "{base_url}/pets/{value}"
+    field: url
+  family:
+    type: lookup
+    file: families.json
+    missing: keep
+  version:
+    type: constant
+    value: {version: 2}
+passes:
+  - repeats: 2
+    rules:
+      - {mark: pet_id, mode: ReplaceValue, key: pet_uuid, generator: petUUID}
+      - {mark: pet_uuid, mode: Insert, key: uuid, generator: petURL}
+      - {mark: pet_family_id, mode: ReplaceValue, key: family, generator: family}
+      - {mark: pet_children, mode: Delete}
+      - {mark: kind, mode: Replace, generator: version}
+`)
+	const input = `{"pet_id": 1, "pet_family_id": 9, "nick": "KittyCat", "pet_children": [2, 3], "kind": "pet"}`
+	config := filepath.Join(dir, "passes.yaml")
+	params := filepath.Join(dir, "params.json")
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "output",
+			args: []string{"-config", config, "-params", params},
+			want: `{"uuid":"74ea3f44-ba35-4d2d-8a3e-01fb4c458df4",` +
+				`"url":"https://zoo.com/pets/74ea3f44-ba35-4d2d-8a3e-01fb4c458df4", ` +
+				`"family":{"name":"Cat"}, "nick": "KittyCat",  "version":2}`,
+		},
+		{
+			name: "dry run",
+			args: []string{"-config", config, "-params", params, "--dry-run"},
+			want: `pass 1, repeat 1:
+  ReplaceValue(pet_id) at 1: 1
+  ReplaceValue(pet_family_id) at 14: 9
+  Delete(pet_children) at 54: [2,3]
+  Replace(kind) at 78: "pet"
+pass 1, repeat 2:
+  Insert(pet_uuid) at 1: "74ea3f44-ba35-4d2d-8a3e-01fb4c458df4"
+`,
+		},
+		{
+			name: "diff",
+			args: []string{"-config", config, "-params", params, "--diff"},
+			want: `--- input
++++ output
+@@ -1,10 +1,9 @@
+ {
+-  "pet_id": 1,
+-  "pet_family_id": 9,
++  "uuid": "74ea3f44-ba35-4d2d-8a3e-01fb4c458df4",
++  "url": "https://zoo.com/pets/74ea3f44-ba35-4d2d-8a3e-01fb4c458df4",
++  "family": {
++    "name": "Cat"
++  },
+   "nick": "KittyCat",
+-  "pet_children": [
+-    2,
+-    3
+-  ],
+-  "kind": "pet"
++  "version": 2
+ }
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if err := run(tt.args, strings.NewReader(input), &stdout, &stderr); err != nil {
+				t.Fatal(err, stderr.String())
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, stdout.String())
+			}
+		})
+	}
+
+	t.Run("trace", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		args := []string{"-config", config, "-params", params, "-trace"}
+		if err := run(args, strings.NewReader(input), &stdout, &stderr); err != nil {
+			t.Fatal(err, stderr.String())
+		}
+		const want = `pass 1, repeat 1:
+  ReplaceValue(pet_id) at /pet_id [1:12]: 1 -> "pet_uuid": "74ea3f44-ba35-4d2d-8a3e-01fb4c458df4"
+`
+		if !strings.HasPrefix(stderr.String(), want) {
+			t.Errorf("Not equal:\n  expected prefix: %s\n  actual: %s", want, stderr.String())
+		}
+	})
+
+	t.Run("dry run and diff", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		err := run([]string{"-config", config, "-dry-run", "-diff"}, strings.NewReader(input), &stdout, &stderr)
+		var usageErr usageError
+		if !errors.As(err, &usageErr) || err.Error() != "-dry-run and -diff are mutually exclusive" {
+			t.Errorf("usage error expected, got %v", err)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		writeFile(t, dir, "invalid.yaml", "passes:\n  - rules:\n      - {mark: a, mode: Insert, key: b, generator: c}\n")
+		var stdout, stderr bytes.Buffer
+		err := run([]string{"-config", filepath.Join(dir, "invalid.yaml")}, strings.NewReader(input), &stdout, &stderr)
+		want := filepath.Join(dir, "invalid.yaml") + ":3:52: unknown generator 'c'"
+		if err == nil || err.Error() != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %v", want, err)
+		}
+	})
+}
+
+func writeFile(t *testing.T, dir, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{a: "", b: "", want: ""},
+		{a: "a b c", b: "a b c", want: " a  b  c"},
+		{a: "a b c", b: "", want: "-a -b -c"},
+		{a: "a b c a b b a", b: "c b a b a c", want: "-a +c  b -c  a  b -b  a +c"},
+		{a: "x a y", b: "a z", want: "-x  a -y +z"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, l := range diffLines(strings.Fields(tt.a), strings.Fields(tt.b)) {
+			got = append(got, string(l.op)+l.text)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("%q -> %q: expected: %q, actual: %q", tt.a, tt.b, tt.want, strings.Join(got, " "))
+		}
+	}
+}
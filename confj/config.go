@@ -0,0 +1,374 @@
+// Package confj builds jsonj passes from declarative configuration written in JSON or YAML.
+//
+// Configuration example:
+//
+//	generators: # optional, generators built by factories registered in GeneratorRegistry
+//	  petURL:
+//	    type: template # factory type, other fields are passed to the factory
+//	    template: https://zoo.com/pets/{value}
+//	passes:
+//	  - repeats: 2 # 1 by default
+//	    rules:
+//	      - mark: pet_id
+//	        mode: ReplaceValue
+//	        key: pet_uuid
+//	        generator: petUUID # name registered in GeneratorRegistry
+//	      - mark: pet_uuid
+//	        mode: Insert
+//	        key: uuid
+//	        generator: petURL
+//	      - mark: pet_children
+//	        mode: Delete
+//
+// Modes are named as jsonj.RuleMode values: Insert, Delete, Replace, ReplaceValue, Wrap, Unwrap,
+// MapElements and AppendElements.
+// Key of Wrap mode is the field wrapping value.
+//
+// Redact and FilterElements modes and Rule.When conditions aren't configured: they take Go values,
+// redact strategies and predicates, rather than generators. Add such rules to loaded passes by code.
+package confj
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/cyberstudio/jsonj"
+)
+
+// Error describes invalid configuration value
+type Error struct {
+	File   string // empty if configuration isn't loaded from file
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// ErrorList describes all invalid values of configuration
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, 0, len(l))
+	for _, err := range l {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// LoadFile reads configuration file and builds passes using generators of registry
+func LoadFile(path string, registry *GeneratorRegistry) ([]jsonj.Pass, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return load(data, path, registry)
+}
+
+// Load builds passes described by configuration data using generators of registry.
+// Nil registry has no generators, so only rules without generators are loaded then.
+//
+// It returns ErrorList if configuration is invalid.
+func Load(data []byte, registry *GeneratorRegistry) ([]jsonj.Pass, error) {
+	return load(data, "", registry)
+}
+
+func load(data []byte, file string, registry *GeneratorRegistry) ([]jsonj.Pass, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		if file != "" {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return nil, err
+	}
+	if registry == nil {
+		registry = NewGeneratorRegistry()
+	}
+	d := decoder{file: file, registry: registry}
+	if len(doc.Content) == 0 {
+		d.errorf(&yaml.Node{Line: 1, Column: 1}, "passes are missing")
+		return nil, d.errs
+	}
+	passes := d.decodeConfig(doc.Content[0])
+	if len(d.errs) > 0 {
+		return nil, d.errs
+	}
+	return passes, nil
+}
+
+type decoder struct {
+	file     string
+	registry *GeneratorRegistry
+	defined  map[string]jsonj.GenerateFragmentBatchFunc // generators defined in configuration
+	errs     ErrorList
+}
+
+func (d *decoder) errorf(n *yaml.Node, format string, args ...interface{}) {
+	d.errs = append(d.errs, &Error{
+		File:   d.file,
+		Line:   n.Line,
+		Column: n.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (d *decoder) decodeConfig(n *yaml.Node) []jsonj.Pass {
+	fields := d.mapping(n, "generators", "passes")
+	if fields == nil {
+		return nil
+	}
+	if generators := fields["generators"]; generators != nil {
+		d.decodeGenerators(generators)
+	}
+	list := d.sequence(n, fields["passes"], "passes")
+	passes := make([]jsonj.Pass, 0, len(list))
+	for _, item := range list {
+		if pass, ok := d.decodePass(item); ok {
+			passes = append(passes, pass)
+		}
+	}
+	return passes
+}
+
+func (d *decoder) decodeGenerators(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		d.errorf(n, "object expected")
+		return
+	}
+	d.defined = make(map[string]jsonj.GenerateFragmentBatchFunc, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		name, definition := n.Content[i], n.Content[i+1]
+		if _, exists := d.lookup(name.Value); exists {
+			d.errorf(name, "generator '%s' already exists", name.Value)
+			continue
+		}
+		if definition.Kind != yaml.MappingNode {
+			d.errorf(definition, "object expected")
+			continue
+		}
+		var typ *yaml.Node
+		for j := 0; j+1 < len(definition.Content); j += 2 {
+			if definition.Content[j].Value == "type" {
+				typ = definition.Content[j+1]
+			}
+		}
+		if typ = d.scalar(definition, typ, "type"); typ == nil || typ.Value == "" {
+			continue
+		}
+		factory, ok := d.registry.factory(typ.Value)
+		if !ok {
+			d.errorf(typ, "unknown generator type '%s'", typ.Value)
+			continue
+		}
+		batchFunc, err := factory(definition.Decode)
+		if err != nil {
+			d.errorf(definition, "invalid generator '%s': %s", name.Value, err)
+			continue
+		}
+		d.defined[name.Value] = batchFunc
+	}
+}
+
+// lookup returns generator defined in configuration or registered in registry
+func (d *decoder) lookup(name string) (jsonj.GenerateFragmentBatchFunc, bool) {
+	if batchFunc, ok := d.defined[name]; ok {
+		return batchFunc, true
+	}
+	return d.registry.Lookup(name)
+}
+
+func (d *decoder) decodePass(n *yaml.Node) (jsonj.Pass, bool) {
+	errCount := len(d.errs)
+	fields := d.mapping(n, "repeats", "rules")
+	if fields == nil {
+		return jsonj.Pass{}, false
+	}
+
+	repeats := 1
+	if v := fields["repeats"]; v != nil {
+		if err := v.Decode(&repeats); err != nil || repeats < 1 {
+			d.errorf(v, "repeats should be positive integer, got '%s'", v.Value)
+		}
+	}
+
+	var rules []*jsonj.Rule
+	ruleByMark := make(map[string]*yaml.Node)
+	for _, item := range d.sequence(n, fields["rules"], "rules") {
+		rule, mark, ok := d.decodeRule(item)
+		if !ok {
+			continue
+		}
+		if prev, exists := ruleByMark[mark.Value]; exists {
+			d.errorf(mark, "rule for the mark '%s' already exists at line %d", mark.Value, prev.Line)
+			continue
+		}
+		ruleByMark[mark.Value] = mark
+		rules = append(rules, rule)
+	}
+	if len(d.errs) > errCount {
+		return jsonj.Pass{}, false
+	}
+	return jsonj.Pass{RuleSet: jsonj.NewRuleSet(rules...), Repeats: repeats}, true
+}
+
+// decodeRule returns rule and its mark node
+func (d *decoder) decodeRule(n *yaml.Node) (*jsonj.Rule, *yaml.Node, bool) {
+	errCount := len(d.errs)
+	fields := d.mapping(n, "mark", "mode", "key", "generator")
+	if fields == nil {
+		return nil, nil, false
+	}
+
+	mark := d.scalar(n, fields["mark"], "mark")
+	modeNode := d.scalar(n, fields["mode"], "mode")
+	if len(d.errs) > errCount {
+		return nil, nil, false
+	}
+	mode, ok := parseMode(modeNode.Value)
+	if !ok {
+		d.errorf(modeNode, "unknown mode '%s', expected one of: %s", modeNode.Value, strings.Join(modeNames(), ", "))
+		return nil, nil, false
+	}
+
+	var key string
+	switch keyNode := fields["key"]; {
+	case !usesKey(mode):
+		if keyNode != nil {
+			d.errorf(keyNode, "key isn't used by %s mode", mode)
+		}
+	case keyNode == nil:
+		d.errorf(n, "key is missing")
+	default:
+		key = d.scalar(n, keyNode, "key").Value
+		if mode != jsonj.ModeReplaceValue && mode != jsonj.ModeWrap && key == mark.Value {
+			d.errorf(keyNode, "key should not be equal mark")
+		}
+	}
+
+	var batchFunc jsonj.GenerateFragmentBatchFunc
+	switch genNode := fields["generator"]; {
+	case mode == jsonj.ModeDelete || mode == jsonj.ModeUnwrap:
+		if genNode != nil {
+			d.errorf(genNode, "generator isn't used by %s mode", mode)
+		}
+	case genNode == nil:
+		d.errorf(n, "generator is missing")
+	default:
+		if name := d.scalar(n, genNode, "generator").Value; len(d.errs) == errCount {
+			if batchFunc, ok = d.lookup(name); !ok {
+				d.errorf(genNode, "unknown generator '%s'", name)
+			}
+		}
+	}
+
+	if len(d.errs) > errCount {
+		return nil, nil, false
+	}
+	return jsonj.NewRule(mode, mark.Value, key, batchFunc), mark, true
+}
+
+// mapping returns fields of mapping node n, unknown fields are reported
+func (d *decoder) mapping(n *yaml.Node, known ...string) map[string]*yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		d.errorf(n, "object expected")
+		return nil
+	}
+	fields := make(map[string]*yaml.Node, len(known))
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if !contains(known, key.Value) {
+			d.errorf(key, "unknown field '%s', expected one of: %s", key.Value, strings.Join(known, ", "))
+			continue
+		}
+		if _, exists := fields[key.Value]; exists {
+			d.errorf(key, "field '%s' already exists", key.Value)
+			continue
+		}
+		fields[key.Value] = value
+	}
+	return fields
+}
+
+// sequence returns items of required field n of parent
+func (d *decoder) sequence(parent, n *yaml.Node, name string) []*yaml.Node {
+	switch {
+	case n == nil:
+		d.errorf(parent, "%s are missing", name)
+		return nil
+	case n.Kind != yaml.SequenceNode:
+		d.errorf(n, "%s should be list", name)
+		return nil
+	case len(n.Content) == 0:
+		d.errorf(n, "%s are missing", name)
+		return nil
+	}
+	return n.Content
+}
+
+// scalar returns required field n of parent
+func (d *decoder) scalar(parent, n *yaml.Node, name string) *yaml.Node {
+	switch {
+	case n == nil:
+		d.errorf(parent, "%s is missing", name)
+	case n.Kind != yaml.ScalarNode:
+		d.errorf(n, "%s should be string", name)
+	case n.Value == "":
+		d.errorf(n, "%s is missing", name)
+	}
+	return n
+}
+
+var modes = []jsonj.RuleMode{
+	jsonj.ModeInsert,
+	jsonj.ModeDelete,
+	jsonj.ModeReplace,
+	jsonj.ModeReplaceValue,
+	jsonj.ModeWrap,
+	jsonj.ModeUnwrap,
+	jsonj.ModeMapElements,
+	jsonj.ModeAppendElements,
+}
+
+// usesKey reports whether rules of the mode have key
+func usesKey(mode jsonj.RuleMode) bool {
+	switch mode {
+	case jsonj.ModeInsert, jsonj.ModeReplaceValue, jsonj.ModeWrap:
+		return true
+	default:
+		return false
+	}
+}
+
+func parseMode(name string) (jsonj.RuleMode, bool) {
+	for _, mode := range modes {
+		if mode.String() == name {
+			return mode, true
+		}
+	}
+	return jsonj.ModeUndefined, false
+}
+
+func modeNames() []string {
+	names := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		names = append(names, mode.String())
+	}
+	return names
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
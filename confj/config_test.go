@@ -0,0 +1,197 @@
+package confj
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cyberstudio/jsonj"
+)
+
+func TestLoad(t *testing.T) {
+	registry := NewGeneratorRegistry()
+	registry.Register("length", func(_ context.Context, iterator jsonj.FragmentIterator, _ interface{}) ([]interface{}, error) {
+		type Output struct {
+			Length int `json:"length"`
+		}
+		var entities []interface{}
+		for iterator.Next() {
+			var value string
+			if err := iterator.BindParams(&value); err != nil {
+				return nil, err
+			}
+			entities = append(entities, Output{Length: len(value)})
+		}
+		return entities, nil
+	})
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name: "yaml",
+			config: `
+passes:
+  - repeats: 1
+    rules:
+      - mark: name
+        mode: Insert
+        key: nick
+        generator: length
+      - mark: id
+        mode: Delete
+  - rules:
+      - mark: nick
+        mode: ReplaceValue
+        key: nick
+        generator: length
+`,
+		},
+		{
+			name: "json",
+			config: `{
+  "passes": [
+    {
+      "repeats": 1,
+      "rules": [
+        {"mark": "name", "mode": "Insert", "key": "nick", "generator": "length"},
+        {"mark": "id", "mode": "Delete"}
+      ]
+    },
+    {
+      "rules": [
+        {"mark": "nick", "mode": "ReplaceValue", "key": "nick", "generator": "length"}
+      ]
+    }
+  ]
+}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passes, err := Load([]byte(tt.config), registry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := jsonj.Process(context.Background(), []byte(`{"id": 1, "name": "KittyCat"}`), jsonj.ProcessParams{
+				Passes: passes,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			const want = `{ "nick":{"length":8},"length":8}`
+			if string(got) != want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, got)
+			}
+		})
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	registry := NewGeneratorRegistry()
+	registry.Register("gen", jsonj.EmptyFragmentsGenerator)
+	registry.RegisterFactory("empty", func(decode func(v interface{}) error) (jsonj.GenerateFragmentBatchFunc, error) {
+		var spec struct {
+			Fail bool `yaml:"fail"`
+		}
+		if err := decode(&spec); err != nil {
+			return nil, err
+		}
+		if spec.Fail {
+			return nil, errors.New("failed")
+		}
+		return jsonj.EmptyFragmentsGenerator, nil
+	})
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name:   "empty",
+			config: ``,
+			want:   "1:1: passes are missing",
+		},
+		{
+			name:   "passes are missing",
+			config: `{"pass": []}`,
+			want:   "1:2: unknown field 'pass', expected one of: generators, passes\n1:1: passes are missing",
+		},
+		{
+			name: "invalid generators",
+			config: `
+generators:
+  gen: {type: empty}
+  a: {type: unknown}
+  b: {fail: true}
+  c: {type: empty, fail: true}
+passes:
+  - rules:
+      - {mark: a, mode: Replace, generator: a}`,
+			want: "3:3: generator 'gen' already exists\n" +
+				"4:13: unknown generator type 'unknown'\n" +
+				"5:6: type is missing\n" +
+				"6:6: invalid generator 'c': failed\n" +
+				"9:45: unknown generator 'a'",
+		},
+		{
+			name: "invalid repeats",
+			config: `
+passes:
+  - repeats: -1
+    rules:
+      - {mark: a, mode: Delete}`,
+			want: "3:14: repeats should be positive integer, got '-1'",
+		},
+		{
+			name: "invalid rules",
+			config: `
+passes:
+  - rules:
+      - {mark: a, mode: Remove}
+      - {mark: b, mode: Insert, generator: gen}
+      - {mark: c, mode: Replace, key: d, generator: gen}
+      - {mark: e, mode: ReplaceValue, key: f, generator: unknown}
+      - {mark: g, mode: Delete}
+      - {mode: Delete}
+      - {mark: g, mode: Delete}
+      - {mark: h, mode: Delete, generator: gen}
+      - {mark: h, mode: Insert, key: h, generator: gen}
+      - {mark: i, mode: Unwrap, key: j}
+      - {mark: k, mode: Wrap, key: k}
+      - {mark: l, mode: Insert, key: m, generator: [gen]}`,
+			want: "4:25: unknown mode 'Remove', expected one of: Insert, Delete, Replace, ReplaceValue, Wrap, Unwrap, MapElements, AppendElements\n" +
+				"5:9: key is missing\n" +
+				"6:39: key isn't used by Replace mode\n" +
+				"7:58: unknown generator 'unknown'\n" +
+				"9:9: mark is missing\n" +
+				"10:16: rule for the mark 'g' already exists at line 8\n" +
+				"11:44: generator isn't used by Delete mode\n" +
+				"12:38: key should not be equal mark\n" +
+				"13:38: key isn't used by Unwrap mode\n" +
+				"14:9: generator is missing\n" +
+				"15:52: generator should be string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load([]byte(tt.config), registry)
+			var errList ErrorList
+			if !errors.As(err, &errList) {
+				t.Fatalf("ErrorList expected, got %v", err)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, err)
+			}
+		})
+	}
+	t.Run("nil registry", func(t *testing.T) {
+		_, err := Load([]byte("passes:\n  - rules:\n      - {mark: a, mode: Replace, generator: gen}\n"), nil)
+		const want = "3:45: unknown generator 'gen'"
+		if err == nil || err.Error() != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %v", want, err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package confj
+
+import "github.com/cyberstudio/jsonj"
+
+// GeneratorRegistry maps generator names used in configuration to fragments generators
+type GeneratorRegistry struct {
+	generators map[string]jsonj.GenerateFragmentBatchFunc
+	factories  map[string]GeneratorFactory
+}
+
+// GeneratorFactory builds generator defined in configuration.
+// Function decode unmarshals fields of the definition into v, see yaml.Node.Decode.
+type GeneratorFactory func(decode func(v interface{}) error) (jsonj.GenerateFragmentBatchFunc, error)
+
+func NewGeneratorRegistry() *GeneratorRegistry {
+	return &GeneratorRegistry{
+		generators: make(map[string]jsonj.GenerateFragmentBatchFunc),
+		factories:  make(map[string]GeneratorFactory),
+	}
+}
+
+// Register adds generator available by name
+func (r *GeneratorRegistry) Register(name string, batchFunc jsonj.GenerateFragmentBatchFunc) {
+	if name == "" {
+		panic("generator name is missing")
+	}
+	if batchFunc == nil {
+		panic("batchFunc is missing")
+	}
+	if _, exists := r.generators[name]; exists {
+		panic("generator already registered: " + name)
+	}
+	r.generators[name] = batchFunc
+}
+
+// Lookup returns generator registered by name
+func (r *GeneratorRegistry) Lookup(name string) (jsonj.GenerateFragmentBatchFunc, bool) {
+	batchFunc, ok := r.generators[name]
+	return batchFunc, ok
+}
+
+// RegisterFactory adds factory of generators defined in configuration with the type
+func (r *GeneratorRegistry) RegisterFactory(typ string, factory GeneratorFactory) {
+	if typ == "" {
+		panic("generator type is missing")
+	}
+	if factory == nil {
+		panic("factory is missing")
+	}
+	if _, exists := r.factories[typ]; exists {
+		panic("generator factory already registered: " + typ)
+	}
+	r.factories[typ] = factory
+}
+
+// factory returns generator factory registered by type
+func (r *GeneratorRegistry) factory(typ string) (GeneratorFactory, bool) {
+	factory, ok := r.factories[typ]
+	return factory, ok
+}
@@ -0,0 +1,56 @@
+package jsonj
+
+// NewMapElementsRule creates rule replacing each element of array value of mark by fragment.
+// Elements of all marks are passed to batchFunc in one batch, values other than arrays are kept.
+func NewMapElementsRule(mark string, batchFunc GenerateFragmentBatchFunc) *Rule {
+	return NewRule(ModeMapElements, mark, "", batchFunc)
+}
+
+// NewFilterElementsRule creates rule removing elements of array value of mark not matching predicate,
+// values other than arrays are kept.
+func NewFilterElementsRule(mark string, predicate Predicate) *Rule {
+	if mark == "" {
+		panic("mark is missing")
+	}
+	if predicate == nil {
+		panic("predicate is missing")
+	}
+	return &Rule{
+		mark:     mark,
+		mode:     ModeFilterElements,
+		genBatch: EmptyFragmentsGenerator,
+		when:     isArray,
+		element:  predicate,
+	}
+}
+
+// NewAppendElementsRule creates rule appending elements to array value of mark.
+// Generator receives array values and returns slice of elements to be appended for each of them,
+// values other than arrays are kept.
+func NewAppendElementsRule(mark string, batchFunc GenerateFragmentBatchFunc) *Rule {
+	return NewRule(ModeAppendElements, mark, "", batchFunc)
+}
+
+// arrayElement describes element of json array
+type arrayElement struct {
+	commaPos int // position of comma before element, -1 for the first element
+	valuePos int // position of value first char
+	endPos   int // position after value last char
+}
+
+// arrayElements returns elements of json array starting at start
+func arrayElements(data []byte, start int) []arrayElement {
+	var elements []arrayElement
+	commaPos := -1
+	i := skipSpaces(data, start+1)
+	for i < len(data) && data[i] != ']' {
+		end := i + findJSONFragmentEnd(data[i:])
+		elements = append(elements, arrayElement{commaPos: commaPos, valuePos: i, endPos: end})
+		commaPos = -1
+		if i = skipSpaces(data, end); i < len(data) && data[i] == ',' {
+			commaPos = i
+			i = skipSpaces(data, i+1)
+		}
+	}
+	return elements
+}
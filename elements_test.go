@@ -0,0 +1,153 @@
+package jsonj
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestProcess_elements(t *testing.T) {
+	var batches []int
+	double := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		batches = append(batches, iterator.Count())
+		result := make([]interface{}, 0, iterator.Count())
+		for iterator.Next() {
+			var n int
+			if err := iterator.BindParams(&n); err != nil {
+				return nil, err
+			}
+			result = append(result, n*2)
+		}
+		return result, nil
+	}
+	appendIDs := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		result := make([]interface{}, 0, iterator.Count())
+		for iterator.Next() {
+			var ids []int
+			if err := iterator.BindParams(&ids); err != nil {
+				return nil, err
+			}
+			if len(ids) > 1 {
+				result = append(result, nil)
+				continue
+			}
+			result = append(result, []int{len(ids) + 10, len(ids) + 20})
+		}
+		return result, nil
+	}
+
+	tests := []struct {
+		name    string
+		rule    *Rule
+		params  ProcessParams
+		repeats int // 1 if zero
+		input   string
+		want    string
+		batches []int
+	}{
+		{
+			name:    "map",
+			rule:    NewMapElementsRule("ids", double),
+			input:   `[{"ids": [1, 2 ,3]}, {"ids": []}, {"ids": 4}, {"ids": [5]}]`,
+			want:    `[{"ids": [2, 4 ,6]}, {"ids": []}, {"ids": 4}, {"ids": [10]}]`,
+			batches: []int{4},
+		},
+		{
+			name:    "map once per pass",
+			rule:    NewMapElementsRule("ids", double),
+			repeats: 2,
+			input:   `{"ids": [1, 2]}`,
+			want:    `{"ids": [2, 4]}`,
+			batches: []int{2},
+		},
+		{
+			name:   "map indented",
+			rule:   NewMapElementsRule("ids", Constant(map[string]int{"id": 1})),
+			params: ProcessParams{PreserveIndent: true},
+			input:  "{\n  \"ids\": [\n    1\n  ]\n}",
+			want:   "{\n  \"ids\": [\n    {\n      \"id\": 1\n    }\n  ]\n}",
+		},
+		{
+			name:  "filter",
+			rule:  NewFilterElementsRule("ids", IsNumber),
+			input: `[{"ids": ["a", 1, "b", "c", 2, "d"]}, {"ids": ["a"]}, {"ids": [1, "a", "b"]}, {"ids": "a"}]`,
+			want:  `[{"ids": [ 1, 2]}, {"ids": []}, {"ids": [1]}, {"ids": "a"}]`,
+		},
+		{
+			name:   "filter indented",
+			rule:   NewFilterElementsRule("ids", IsNumber),
+			params: ProcessParams{PreserveIndent: true},
+			input:  "{\n  \"ids\": [\n    \"a\",\n    1,\n    \"b\"\n  ]\n}",
+			want:   "{\n  \"ids\": [\n    1\n  ]\n}",
+		},
+		{
+			name:  "append",
+			rule:  NewAppendElementsRule("ids", appendIDs),
+			input: `[{"ids": [1 ]}, {"ids": [ ]}, {"ids": [1, 2]}, {"ids": null}]`,
+			want:  `[{"ids": [1,11,21 ]}, {"ids": [10,20 ]}, {"ids": [1, 2]}, {"ids": null}]`,
+		},
+		{
+			name:    "append once per pass",
+			rule:    NewAppendElementsRule("ids", Constant([]int{9})),
+			repeats: 2,
+			input:   `{"ids": [1,2]}`,
+			want:    `{"ids": [1,2,9]}`,
+		},
+		{
+			name:   "append indented",
+			rule:   NewAppendElementsRule("ids", appendIDs),
+			params: ProcessParams{PreserveIndent: true},
+			input:  "{\n  \"ids\": [\n    1\n  ],\n  \"empty\": {\n    \"ids\": [ ]\n  }\n}",
+			want: "{\n  \"ids\": [\n    1,\n    11,\n    21\n  ],\n" +
+				"  \"empty\": {\n    \"ids\": [\n      10,\n      20\n    ]\n  }\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches = nil
+			tt.params.Passes = []Pass{{RuleSet: NewRuleSet(tt.rule), Repeats: max(tt.repeats, 1)}}
+			output, err := Process(context.Background(), []byte(tt.input), tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+			if tt.batches != nil && !slices.Equal(batches, tt.batches) {
+				t.Errorf("Not equal batches:\n  expected: %v\n  actual: %v", tt.batches, batches)
+			}
+		})
+	}
+}
+
+func TestProcess_appendElementsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment interface{}
+		want     string
+	}{
+		{
+			name:     "bytes",
+			fragment: []byte{1, 2},
+			want: "unable to do pass 0: unable to write elements append for mark 'l': " +
+				`AppendElements mode suspects array fragment, got "AQI="`,
+		},
+		{
+			name:     "marshaler",
+			fragment: json.RawMessage(`{"a": 1}`),
+			want: "unable to do pass 0: unable to write elements append for mark 'l': " +
+				`AppendElements mode suspects array fragment, got {"a":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(context.Background(), []byte(`{"l": [1]}`), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(NewAppendElementsRule("l", Constant(tt.fragment))), Repeats: 1}},
+			})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package jsonj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// OutputFormat determines formatting of the whole output of Process
+type OutputFormat int
+
+const (
+	OutputAsIs      OutputFormat = iota // input formatting is kept
+	OutputCompact                       // insignificant whitespaces are removed
+	OutputIndent                        // each element is written on its own line, see ProcessParams.OutputIndent
+	OutputCanonical                     // RFC 8785 JSON Canonicalization Scheme (JCS), byte-stable output
+)
+
+func (f OutputFormat) String() string {
+	switch f {
+	case OutputAsIs:
+		return "AsIs"
+	case OutputCompact:
+		return "Compact"
+	case OutputIndent:
+		return "Indent"
+	case OutputCanonical:
+		return "Canonical"
+	default:
+		panic("unknown output format value")
+	}
+}
+
+// formatOutput writes data to b formatted as per format
+func formatOutput(b *bytes.Buffer, data []byte, format OutputFormat, indent string) error {
+	switch format {
+	case OutputAsIs:
+		_, err := b.Write(data)
+		return err
+	case OutputCompact:
+		return json.Compact(b, data)
+	case OutputIndent:
+		if indent == "" {
+			indent = "  "
+		}
+		return json.Indent(b, data, "", indent)
+	case OutputCanonical:
+		return writeCanonical(b, data)
+	default:
+		panic("unknown output format: " + format.String())
+	}
+}
+
+// writeCanonical writes json data canonicalized as per RFC 8785:
+// no whitespaces, object keys are sorted by UTF-16 code units,
+// numbers are serialized as ECMAScript does and strings use minimal escaping.
+//
+// Duplicate keys aren't allowed by RFC 8785, the last value is kept.
+func writeCanonical(b *bytes.Buffer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	buf, err := appendCanonical(b.AvailableBuffer(), v)
+	if err != nil {
+		return err
+	}
+	_, err = b.Write(buf)
+	return err
+}
+
+func appendCanonical(dst []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(dst, nullLiteral...), nil
+	case bool:
+		return strconv.AppendBool(dst, v), nil
+	case string:
+		return appendJSONString(dst, v), nil
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to canonicalize number %s: %w", v, err)
+		}
+		return appendCanonicalNumber(dst, f), nil
+	case []interface{}:
+		dst = append(dst, '[')
+		for i, item := range v {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			var err error
+			if dst, err = appendCanonical(dst, item); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, ']'), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+		dst = append(dst, '{')
+		for i, k := range keys {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendJSONString(dst, k)
+			dst = append(dst, ':')
+			var err error
+			if dst, err = appendCanonical(dst, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, '}'), nil
+	default:
+		panic(fmt.Sprintf("unexpected json value type %T", v))
+	}
+}
+
+// appendCanonicalNumber appends number serialized as per ECMAScript Number.prototype.toString
+func appendCanonicalNumber(dst []byte, f float64) []byte {
+	if f == 0 { // including -0
+		return append(dst, '0')
+	}
+	if abs := math.Abs(f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.AppendFloat(dst, f, 'f', -1, 64)
+	}
+	// exponent without leading zeros: 1e+21, 1.5e-7
+	l := len(dst)
+	dst = strconv.AppendFloat(dst, f, 'e', -1, 64)
+	exp := bytes.IndexByte(dst[l:], 'e') + l + 2 // skip exponent sign
+	n := exp
+	for n < len(dst)-1 && dst[n] == '0' {
+		n++
+	}
+	return append(dst[:exp], dst[n:]...)
+}
+
+// lessUTF16 reports whether a is less than b comparing their UTF-16 code units
+func lessUTF16(a, b string) bool {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			a1, a2 := utf16Units(ra)
+			b1, b2 := utf16Units(rb)
+			if a1 != b1 {
+				return a1 < b1
+			}
+			return a2 < b2
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) < len(b)
+}
+
+// utf16Units returns UTF-16 code units of r, the second one is zero unless r is encoded by surrogate pair
+func utf16Units(r rune) (rune, rune) {
+	if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+		return r1, r2
+	}
+	return r, 0
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as quoted json string with minimal escaping as per RFC 8259:
+// quotation mark, reverse solidus and control characters are escaped,
+// invalid UTF-8 is replaced by U+FFFD.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				dst = append(dst, '\\', c)
+			case c >= 0x20:
+				dst = append(dst, c)
+			case c == '\b':
+				dst = append(dst, '\\', 'b')
+			case c == '\f':
+				dst = append(dst, '\\', 'f')
+			case c == '\n':
+				dst = append(dst, '\\', 'n')
+			case c == '\r':
+				dst = append(dst, '\\', 'r')
+			case c == '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			continue
+		}
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			dst = append(dst, `\ufffd`...)
+		} else {
+			dst = append(dst, s[i:i+n]...)
+		}
+		i += n
+	}
+	return append(dst, '"')
+}
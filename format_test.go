@@ -0,0 +1,133 @@
+package jsonj
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProcess_output(t *testing.T) {
+	generateLink := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		type Output struct {
+			Link string `json:"link"`
+			Tags []int  `json:"tags"`
+		}
+		var entities []interface{}
+		for iterator.Next() {
+			entities = append(entities, Output{Link: "<a>&</a>", Tags: []int{1}})
+		}
+		return entities, nil
+	}
+	passes := []Pass{{
+		RuleSet: NewRuleSet(NewInsertRule("mark", "key", generateLink)),
+		Repeats: 1,
+	}}
+
+	tests := []struct {
+		name   string
+		params ProcessParams
+		input  string
+		want   string
+	}{
+		{
+			name:   "as is",
+			params: ProcessParams{Passes: passes},
+			input:  `{ "mark" : 1 }`,
+			want:   `{ "key": 1,"link":"\u003ca\u003e\u0026\u003c/a\u003e","tags":[1] }`,
+		},
+		{
+			name:   "html escape disabled",
+			params: ProcessParams{Passes: passes, DisableHTMLEscape: true},
+			input:  `{ "mark" : 1 }`,
+			want:   `{ "key": 1,"link":"<a>&</a>","tags":[1] }`,
+		},
+		{
+			name:   "fragment indent",
+			params: ProcessParams{Passes: passes, FragmentIndent: "\t", DisableHTMLEscape: true},
+			input:  "{\n\"mark\": 1\n}",
+			want:   "{\n\"key\": 1,\n\"link\": \"<a>&</a>\",\n\"tags\": [\n\t1\n]\n}",
+		},
+		{
+			name:   "fragment indent inline mark",
+			params: ProcessParams{Passes: passes, FragmentIndent: "\t", DisableHTMLEscape: true},
+			input:  `{"a": 1, "mark": 2, "b": 3}`,
+			want:   `{"a": 1, "key": 2,"link":"<a>&</a>","tags":[1], "b": 3}`,
+		},
+		{
+			name:   "compact",
+			params: ProcessParams{Passes: passes, Output: OutputCompact},
+			input:  "{\n  \"mark\": 1,\n  \"b\": [ 1, 2 ]\n}",
+			want:   `{"key":1,"link":"\u003ca\u003e\u0026\u003c/a\u003e","tags":[1],"b":[1,2]}`,
+		},
+		{
+			name:   "indent",
+			params: ProcessParams{Passes: passes, Output: OutputIndent, DisableHTMLEscape: true},
+			input:  `{"mark": 1}`,
+			want:   "{\n  \"key\": 1,\n  \"link\": \"<a>&</a>\",\n  \"tags\": [\n    1\n  ]\n}",
+		},
+		{
+			name:   "indent unit",
+			params: ProcessParams{Output: OutputIndent, OutputIndent: "\t"},
+			input:  `{"a": [1]}`,
+			want:   "{\n\t\"a\": [\n\t\t1\n\t]\n}",
+		},
+		{
+			name:   "canonical",
+			params: ProcessParams{Passes: passes, Output: OutputCanonical},
+			input:  `{"z": 1.50, "mark": 1e2, "a": {"c": null, "b": true}}`,
+			want:   `{"a":{"b":true,"c":null},"key":100,"link":"<a>&</a>","tags":[1],"z":1.5}`,
+		},
+		{
+			name:   "canonical RFC 8785 example",
+			params: ProcessParams{Output: OutputCanonical},
+			input: `{
+				"numbers": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001],
+				"string": "\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/",
+				"literals": [null, true, false]
+			}`,
+			want: `{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],` +
+				`"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			name:   "canonical keys order",
+			params: ProcessParams{Output: OutputCanonical},
+			input:  `{"\ud83d\ude00": 1, "\ufb33": 2, "b": 3, "a": 4, "aa": 5}`,
+			want:   "{\"a\":4,\"aa\":5,\"b\":3,\"\U0001F600\":1,\"\uFB33\":2}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Process(context.Background(), []byte(tt.input), tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_appendCanonicalNumber(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{-1, "-1"},
+		{1e21, "1e+21"},
+		{1e20, "100000000000000000000"},
+		{1e-6, "0.000001"},
+		{1e-7, "1e-7"},
+		{-1.5e-7, "-1.5e-7"},
+		{123.456, "123.456"},
+		{9007199254740993, "9007199254740992"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := string(appendCanonicalNumber(nil, tt.value))
+			if got != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, got)
+			}
+		})
+	}
+}
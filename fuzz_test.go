@@ -0,0 +1,204 @@
+package jsonj
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fuzzMarks are marks of fuzzed rule sets, keys of fragments start with 'k'
+var fuzzMarks = []string{"a", "b", "c"}
+
+// fuzzRule is rule of fuzzed rule set applied to decoded json by refApply
+type fuzzRule struct {
+	mode  RuleMode
+	key   string // key of ReplaceValue, Insert, Replace and Wrap fragments
+	array bool   // rule applies to array values only
+}
+
+// fuzzRules returns rule set chosen by bits of seed, 4 bits per mark, and its decoded json model
+func fuzzRules(seed uint16) (*RuleSet, map[string]fuzzRule) {
+	modes := []RuleMode{
+		ModeUndefined, ModeInsert, ModeDelete, ModeReplace, ModeReplaceValue, ModeRedact,
+		ModeWrap, ModeMapElements, ModeFilterElements, ModeAppendElements,
+	}
+	set := NewRuleSet()
+	model := make(map[string]fuzzRule)
+	for i, mark := range fuzzMarks {
+		mode := modes[int(seed>>(4*i)&0xf)%len(modes)]
+		key := "k" + strconv.Itoa(i)
+		var rule *Rule
+		switch mode {
+		case ModeUndefined:
+			continue
+		case ModeInsert:
+			rule = NewInsertRule(mark, key, Constant(map[string]int{key + "i": 1}))
+		case ModeDelete:
+			rule = NewDeleteRule(mark)
+		case ModeReplace:
+			rule = NewReplaceRule(mark, Constant(map[string]int{key: 2}))
+		case ModeReplaceValue:
+			rule = NewReplaceValueRule(mark, key, Constant("v"))
+		case ModeRedact:
+			rule = NewRedactRule(mark, RedactFixed("***"))
+		case ModeWrap:
+			rule = NewWrapRule(mark, key, Constant(map[string]int{key + "w": 3}))
+		case ModeMapElements:
+			rule = NewMapElementsRule(mark, Constant("e"))
+		case ModeFilterElements:
+			rule = NewFilterElementsRule(mark, IsNumber)
+		case ModeAppendElements:
+			rule = NewAppendElementsRule(mark, Constant([]int{4}))
+		}
+		set.AddRule(rule)
+		model[mark] = fuzzRule{
+			mode:  mode,
+			key:   key,
+			array: mode == ModeMapElements || mode == ModeFilterElements || mode == ModeAppendElements,
+		}
+	}
+	return set, model
+}
+
+// refApply applies rules of a pass repeat to json decoded by encoding/json.
+// Values of matched marks aren't processed, like Process skips them.
+func refApply(v interface{}, rules map[string]fuzzRule) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			out = append(out, refApply(elem, rules))
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			rule, ok := rules[key]
+			elements, isArray := value.([]interface{})
+			if !ok || rule.array && !isArray {
+				out[key] = refApply(value, rules)
+				continue
+			}
+			switch rule.mode {
+			case ModeInsert:
+				out[rule.key] = value
+				out[rule.key+"i"] = 1.0
+			case ModeDelete:
+			case ModeReplace:
+				out[rule.key] = 2.0
+			case ModeReplaceValue:
+				out[rule.key] = "v"
+			case ModeRedact:
+				out[key] = "***"
+			case ModeWrap:
+				out[key] = map[string]interface{}{rule.key: value, rule.key + "w": 3.0}
+			case ModeMapElements:
+				mapped := make([]interface{}, len(elements))
+				for i := range mapped {
+					mapped[i] = "e"
+				}
+				out[key] = mapped
+			case ModeFilterElements:
+				filtered := []interface{}{}
+				for _, elem := range elements {
+					if _, ok := elem.(float64); ok {
+						filtered = append(filtered, elem)
+					}
+				}
+				out[key] = filtered
+			case ModeAppendElements:
+				out[key] = append(elements, 4.0)
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// hasFragmentKeys reports whether decoded json has keys of fragments, output may have duplicate keys then
+func hasFragmentKeys(v interface{}) bool {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, elem := range v {
+			if hasFragmentKeys(elem) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			if strings.HasPrefix(key, "k") || hasFragmentKeys(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func FuzzProcess(f *testing.F) {
+	seeds := []string{
+		`{"a": 1, "b": "x", "c": null}`,
+		`[{"a": [1, "2", {"b": [3]}]}, {"c": {"a": true}}]`,
+		`{"a": {"b": {"c": [1, 2.5e3, -0.1]}}, "b": [], "c": {}}`,
+		`{"a": "esc\"aped", "b" : [ {"c" : "x"} ] }`,
+		"{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": {\n    \"c\": false\n  }\n}",
+		`{"a":"\"b\":1","b":1}`,
+		`{"s": "\\", "\u0061": {"x\"": "\"c\" :"}, "c": [1]}`,
+		`{"a": [1, 2}`,
+		`{"a": 01}`,
+		"{\"a\":\f{}}",
+	}
+	for i, seed := range seeds {
+		f.Add([]byte(seed), uint16(i*0x1357+0x21), uint8(i%3))
+	}
+	f.Fuzz(func(t *testing.T, input []byte, seed uint16, repeats uint8) {
+		set, model := fuzzRules(seed)
+		pass := Pass{RuleSet: set, Repeats: int(repeats%3) + 1}
+		output, err := Process(context.Background(), input, ProcessParams{Passes: []Pass{pass}, ValidateInput: true})
+
+		if !json.Valid(input) {
+			if !errors.Is(err, ErrInvalidJSON) {
+				t.Fatalf("ErrInvalidJSON expected, got %v: %q", err, input)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error of valid input: %v", err)
+		}
+		if !json.Valid(output) {
+			t.Fatalf("invalid output of valid input:\n  input: %s\n  output: %s", input, output)
+		}
+		reference, err := ReferenceProcess(context.Background(), input, ProcessParams{Passes: []Pass{pass}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normalizeJSON(t, output) != string(reference) {
+			t.Errorf("Not equal to ReferenceProcess:\n  input: %s\n  expected: %s\n  actual: %s", input, reference, output)
+		}
+
+		var want interface{}
+		if err := json.Unmarshal(input, &want); err != nil || hasFragmentKeys(want) {
+			return // i.e. number out of float64 range
+		}
+		for i := 0; i < pass.Repeats; i++ {
+			want = refApply(want, model)
+			for mark, rule := range model {
+				if (&Rule{mode: rule.mode}).keepsMark() {
+					delete(model, mark) // it applies on the first repeat only
+				}
+			}
+		}
+		var got interface{}
+		if err := json.Unmarshal(output, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			wantJSON, _ := json.Marshal(want)
+			t.Errorf("Not equal:\n  input: %s\n  expected: %s\n  actual: %s", input, wantJSON, output)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// MissingPolicy determines fragment generated by MapLookup for keys missing in the map.
+//
+// Fragments of MissingNull and MissingKeep aren't objects, so Insert, Wrap and Replace modes fail on missing keys,
+// use MissingEmpty for them.
+type MissingPolicy int
+
+const (
+	MissingNull  MissingPolicy = iota // null fragment
+	MissingKeep                       // mark value is kept as is by ReplaceValue mode
+	MissingEmpty                      // empty fragment, nothing is inserted by Insert mode
+	MissingError                      // generation fails with KeyNotFoundError
+)
+
+// KeyNotFoundError is returned by MapLookup generator with MissingError policy
+type KeyNotFoundError struct {
+	Key string // raw json of mark value
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key %s not found", e.Key)
+}
+
+// Func returns generator converting every mark value by convert.
+// Function convert receives raw json of mark value without leading and trailing spaces.
+func Func(convert func(raw []byte) (interface{}, error)) GenerateFragmentBatchFunc {
+	if convert == nil {
+		panic("convert is missing")
+	}
+	return func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		result := make([]interface{}, 0, iterator.Count())
+		for iterator.Next() {
+			raw := bytes.TrimSpace(iterator.Bytes())
+			fragment, err := convert(raw)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, fragment)
+		}
+		return result, nil
+	}
+}
+
+// Constant returns generator of v fragment for every mark
+func Constant(v interface{}) GenerateFragmentBatchFunc {
+	return Func(func([]byte) (interface{}, error) {
+		return v, nil
+	})
+}
+
+// Null returns generator of null fragment for every mark
+func Null() GenerateFragmentBatchFunc {
+	return Constant(nil)
+}
+
+// MapLookup returns generator of m values looked up by mark values.
+// Mark value is unmarshalled into K, for string keys values other than json strings
+// are looked up by their raw json, i.e. number 1 by key "1".
+func MapLookup[K comparable, V any](m map[K]V, missing MissingPolicy) GenerateFragmentBatchFunc {
+	return Func(func(raw []byte) (interface{}, error) {
+		var key K
+		if err := json.Unmarshal(raw, &key); err != nil {
+			s, ok := interface{}(&key).(*string)
+			if !ok {
+				return nil, err
+			}
+			*s = string(raw)
+		}
+		if value, ok := m[key]; ok {
+			return value, nil
+		}
+		switch missing {
+		case MissingKeep:
+			return json.RawMessage(raw), nil
+		case MissingEmpty:
+			return struct{}{}, nil
+		case MissingError:
+			return nil, &KeyNotFoundError{Key: string(raw)}
+		default:
+			return nil, nil
+		}
+	})
+}
+
+// URLTemplate returns generator of strings rendered by template like "https://{base}/pets/{value}".
+// Placeholder {value} is replaced by path-escaped mark value, json strings are unquoted.
+// Other placeholders are replaced by params fields: map keys or struct fields matched by name or json tag.
+// It panics if template has unclosed placeholder.
+func URLTemplate(template string) GenerateFragmentBatchFunc {
+	parts := strings.Split(template, "{")
+	for _, part := range parts[1:] {
+		if !strings.Contains(part, "}") {
+			panic("unclosed placeholder in template: " + template)
+		}
+	}
+	return func(_ context.Context, iterator FragmentIterator, p interface{}) ([]interface{}, error) {
+		result := make([]interface{}, 0, iterator.Count())
+		for iterator.Next() {
+			raw := bytes.TrimSpace(iterator.Bytes())
+			var b strings.Builder
+			b.WriteString(parts[0])
+			for _, part := range parts[1:] {
+				end := strings.IndexByte(part, '}')
+				name := part[:end]
+				if name == "value" {
+					b.WriteString(url.PathEscape(rawString(raw)))
+				} else {
+					field, ok := paramField(p, name)
+					if !ok {
+						return nil, fmt.Errorf("param '%s' of template '%s' not found", name, template)
+					}
+					fmt.Fprint(&b, field)
+				}
+				b.WriteString(part[end+1:])
+			}
+			result = append(result, b.String())
+		}
+		return result, nil
+	}
+}
+
+// rawString returns unquoted json string or raw json of other values
+func rawString(raw []byte) string {
+	var s string
+	if len(raw) > 0 && raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// paramField returns map value by key or struct field by name or json tag
+func paramField(p interface{}, name string) (interface{}, bool) {
+	v := reflect.ValueOf(p)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		field := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !field.IsValid() {
+			return nil, false
+		}
+		return field.Interface(), true
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			tag, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+			if sf.Name == name || tag == name {
+				return v.Field(i).Interface(), true
+			}
+		}
+	}
+	return nil, false
+}
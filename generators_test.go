@@ -0,0 +1,172 @@
+package jsonj
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestGenerators(t *testing.T) {
+	uuids := map[int]string{1: "74ea3f44-ba35-4d2d-8a3e-01fb4c458df4"}
+	names := map[string]string{"1": "one", "a": "A"}
+	type urlParams struct {
+		BaseURL string `json:"base"`
+		Version int
+	}
+
+	tests := []struct {
+		name   string
+		rule   *Rule
+		params interface{}
+		input  string
+		want   string
+	}{
+		{
+			name:  "map lookup",
+			rule:  NewReplaceValueRule("id", "uuid", MapLookup(uuids, MissingNull)),
+			input: `[{"id": 1}, {"id": 2}]`,
+			want:  `[{"uuid":"74ea3f44-ba35-4d2d-8a3e-01fb4c458df4"}, {"uuid":null}]`,
+		},
+		{
+			name:  "map lookup by string keys",
+			rule:  NewReplaceValueRule("id", "name", MapLookup(names, MissingNull)),
+			input: `[{"id": 1}, {"id": "a"}, {"id": "1"}]`,
+			want:  `[{"name":"one"}, {"name":"A"}, {"name":"one"}]`,
+		},
+		{
+			name:  "map lookup keeping missing",
+			rule:  NewReplaceValueRule("id", "uuid", MapLookup(uuids, MissingKeep)),
+			input: `[{"id": 1}, {"id": 2 }]`,
+			want:  `[{"uuid":"74ea3f44-ba35-4d2d-8a3e-01fb4c458df4"}, {"uuid":2 }]`,
+		},
+		{
+			name:  "map lookup skipping missing",
+			rule:  NewInsertRule("id", "pid", MapLookup(map[int]map[string]int{1: {"n": 1}}, MissingEmpty)),
+			input: `[{"id": 1}, {"id": 2}]`,
+			want:  `[{"pid": 1,"n":1}, {"pid": 2}]`,
+		},
+		{
+			name:   "url template with map params",
+			rule:   NewReplaceValueRule("id", "url", URLTemplate("{base}/pets/{value}")),
+			params: map[string]string{"base": "https://zoo.com"},
+			input:  `[{"id": 1}, {"id": "a/b"}]`,
+			want:   `[{"url":"https://zoo.com/pets/1"}, {"url":"https://zoo.com/pets/a%2Fb"}]`,
+		},
+		{
+			name:   "url template with struct params",
+			rule:   NewReplaceValueRule("id", "url", URLTemplate("{base}/v{Version}/pets/{value}")),
+			params: &urlParams{BaseURL: "https://zoo.com", Version: 2},
+			input:  `{"id": 1}`,
+			want:   `{"url":"https://zoo.com/v2/pets/1"}`,
+		},
+		{
+			name:  "constant",
+			rule:  NewReplaceRule("kind", Constant(map[string]int{"version": 2})),
+			input: `{"kind": "pet", "id": 1}`,
+			want:  `{ "version":2, "id": 1}`,
+		},
+		{
+			name:  "null",
+			rule:  NewReplaceValueRule("secret", "hidden", Null()),
+			input: `{"secret": {"a": 1}}`,
+			want:  `{"hidden":null}`,
+		},
+		{
+			name: "func",
+			rule: NewReplaceValueRule("id", "code", Func(func(raw []byte) (interface{}, error) {
+				return strconv.Quote(string(raw)), nil
+			})),
+			input: `{"id": 1 }`,
+			want:  `{"code":"\"1\"" }`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(tt.rule), Repeats: 1}},
+				Params: tt.params,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestGenerators_errors(t *testing.T) {
+	convertErr := errors.New("convert error")
+	tests := []struct {
+		name string
+		gen  GenerateFragmentBatchFunc
+		rule *Rule // ReplaceValue rule of gen is used if nil
+		want string
+	}{
+		{
+			name: "missing key",
+			gen:  MapLookup(map[int]int{}, MissingError),
+			want: "unable to do pass 0: fragments generation error for rule 'ReplaceValue(id)': key 1 not found",
+		},
+		{
+			name: "invalid key",
+			gen:  MapLookup(map[bool]int{}, MissingNull),
+			want: "unable to do pass 0: fragments generation error for rule 'ReplaceValue(id)': " +
+				"json: cannot unmarshal number into Go value of type bool",
+		},
+		{
+			name: "missing param",
+			gen:  URLTemplate("{base}/{value}"),
+			want: "unable to do pass 0: fragments generation error for rule 'ReplaceValue(id)': " +
+				"param 'base' of template '{base}/{value}' not found",
+		},
+		{
+			name: "func",
+			gen:  Func(func([]byte) (interface{}, error) { return nil, convertErr }),
+			want: "unable to do pass 0: fragments generation error for rule 'ReplaceValue(id)': convert error",
+		},
+		{
+			name: "missing key inserted as null",
+			rule: NewInsertRule("id", "pid", MapLookup(map[int]map[string]int{}, MissingNull)),
+			want: "unable to do pass 0: unable to write insert for mark 'id': Insert mode suspects object fragment, got null",
+		},
+		{
+			name: "missing key kept by wrap",
+			rule: NewWrapRule("id", "value", MapLookup(map[int]map[string]int{}, MissingKeep)),
+			want: "unable to do pass 0: unable to write wrap for mark 'id': Wrap mode suspects object fragment, got 1",
+		},
+		{
+			name: "null replace",
+			rule: NewReplaceRule("id", Null()),
+			want: "unable to do pass 0: unable to write key-value replacement for mark 'id': Replace mode suspects object fragment, got null",
+		},
+		{
+			name: "string replace",
+			rule: NewReplaceRule("id", Constant("xy")),
+			want: "unable to do pass 0: unable to write key-value replacement for mark 'id': Replace mode suspects object fragment, got \"xy\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			if rule == nil {
+				rule = NewReplaceValueRule("id", "code", tt.gen)
+			}
+			_, err := Process(context.Background(), []byte(`{"id": 1}`), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(rule), Repeats: 1}},
+			})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %v", tt.want, err)
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("URLTemplate doesn't panic on unclosed placeholder")
+		}
+	}()
+	URLTemplate("{base}/{value")
+}
@@ -0,0 +1,41 @@
+package jsonj
+
+import "time"
+
+// Hooks observes Process, i.e. to collect metrics. See SlogHooks adapter.
+//
+// Methods are called synchronously by the goroutine calling Process.
+type Hooks interface {
+	// OnPassStart is called before a pass repeat, pass and repeat start from 1
+	OnPassStart(pass, repeat int)
+	// OnPassEnd is called after a pass repeat
+	OnPassEnd(stats PassStats, err error)
+	// OnGenerate is called after generator call of the rule with count fragments
+	OnGenerate(rule *Rule, count int, d time.Duration, err error)
+	// OnDone is called when Process returns
+	OnDone(stats ProcessStats, err error)
+}
+
+// PassStats describes pass repeat
+type PassStats struct {
+	Pass     int
+	Repeat   int
+	Marks    int // number of rewritten marks and array elements
+	BytesIn  int
+	BytesOut int
+}
+
+// ProcessStats describes Process call
+type ProcessStats struct {
+	BytesIn  int
+	BytesOut int
+	Repeats  int // number of executed pass repeats
+}
+
+// NopHooks does nothing, it's embedded to implement part of Hooks
+type NopHooks struct{}
+
+func (NopHooks) OnPassStart(int, int)                        {}
+func (NopHooks) OnPassEnd(PassStats, error)                  {}
+func (NopHooks) OnGenerate(*Rule, int, time.Duration, error) {}
+func (NopHooks) OnDone(ProcessStats, error)                  {}
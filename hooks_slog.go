@@ -0,0 +1,57 @@
+package jsonj
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogHooks logs Process events by slog.Logger, errors are logged with slog.LevelError.
+// It's an example of Hooks adapter, metrics exporters are wired the same way.
+type SlogHooks struct {
+	Logger *slog.Logger // slog.Default() if nil
+	Level  slog.Level   // level of events without errors
+}
+
+func (h SlogHooks) OnPassStart(pass, repeat int) {
+	h.log(nil, "jsonj pass started", slog.Int("pass", pass), slog.Int("repeat", repeat))
+}
+
+func (h SlogHooks) OnPassEnd(stats PassStats, err error) {
+	h.log(err, "jsonj pass finished",
+		slog.Int("pass", stats.Pass),
+		slog.Int("repeat", stats.Repeat),
+		slog.Int("marks", stats.Marks),
+		slog.Int("bytes_in", stats.BytesIn),
+		slog.Int("bytes_out", stats.BytesOut),
+	)
+}
+
+func (h SlogHooks) OnGenerate(rule *Rule, count int, d time.Duration, err error) {
+	h.log(err, "jsonj fragments generated",
+		slog.String("rule", rule.String()),
+		slog.Int("count", count),
+		slog.Duration("duration", d),
+	)
+}
+
+func (h SlogHooks) OnDone(stats ProcessStats, err error) {
+	h.log(err, "jsonj process finished",
+		slog.Int("bytes_in", stats.BytesIn),
+		slog.Int("bytes_out", stats.BytesOut),
+		slog.Int("repeats", stats.Repeats),
+	)
+}
+
+func (h SlogHooks) log(err error, msg string, attrs ...slog.Attr) {
+	logger := h.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	level := h.Level
+	if err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
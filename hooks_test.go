@@ -0,0 +1,119 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingHooks records events without durations
+type recordingHooks struct {
+	events []string
+}
+
+func (h *recordingHooks) OnPassStart(pass, repeat int) {
+	h.events = append(h.events, fmt.Sprintf("start %d.%d", pass, repeat))
+}
+
+func (h *recordingHooks) OnPassEnd(stats PassStats, err error) {
+	h.events = append(h.events, fmt.Sprintf("end %+v %v", stats, err))
+}
+
+func (h *recordingHooks) OnGenerate(rule *Rule, count int, _ time.Duration, err error) {
+	h.events = append(h.events, fmt.Sprintf("generate %s %d %v", rule, count, err))
+}
+
+func (h *recordingHooks) OnDone(stats ProcessStats, err error) {
+	h.events = append(h.events, fmt.Sprintf("done %+v %v", stats, err))
+}
+
+func TestProcess_hooks(t *testing.T) {
+	genErr := errors.New("generator error")
+	tests := []struct {
+		name  string
+		pass  Pass
+		input string
+		want  []string
+	}{
+		{
+			name: "repeats",
+			pass: Pass{
+				RuleSet: NewRuleSet(NewReplaceValueRule("a", "b", Constant(1)), NewDeleteRule("c")),
+				Repeats: 3,
+			},
+			input: `{"a": 0, "c": 2}`,
+			want: []string{
+				"start 1.1",
+				"generate ReplaceValue(a) 1 <nil>",
+				"generate Delete(c) 1 <nil>",
+				"end {Pass:1 Repeat:1 Marks:2 BytesIn:16 BytesOut:7} <nil>",
+				"start 1.2",
+				"end {Pass:1 Repeat:2 Marks:0 BytesIn:7 BytesOut:7} <nil>",
+				"start 1.3",
+				"end {Pass:1 Repeat:3 Marks:0 BytesIn:7 BytesOut:7} <nil>",
+				"done {BytesIn:16 BytesOut:7 Repeats:3} <nil>",
+			},
+		},
+		{
+			name: "error",
+			pass: Pass{
+				RuleSet: NewRuleSet(NewReplaceValueRule("a", "b", Func(func([]byte) (interface{}, error) {
+					return nil, genErr
+				}))),
+				Repeats: 1,
+			},
+			input: `{"a": 0}`,
+			want: []string{
+				"start 1.1",
+				"generate ReplaceValue(a) 1 generator error",
+				"end {Pass:1 Repeat:1 Marks:0 BytesIn:8 BytesOut:0} fragments generation error for rule 'ReplaceValue(a)': generator error",
+				"done {BytesIn:8 BytesOut:0 Repeats:1} unable to do pass 0: fragments generation error for rule 'ReplaceValue(a)': generator error",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks := &recordingHooks{}
+			_, _ = Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{tt.pass},
+				Hooks:  hooks,
+			})
+			got, want := strings.Join(hooks.events, "\n"), strings.Join(tt.want, "\n")
+			if got != want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, got)
+			}
+		})
+	}
+}
+
+func TestSlogHooks(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	_, err := Process(context.Background(), []byte(`{"a": 0}`), ProcessParams{
+		Passes: []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("a", "b", Constant(1))), Repeats: 1}},
+		Hooks:  SlogHooks{Logger: logger},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `level=INFO msg="jsonj pass started" pass=1 repeat=1
+level=INFO msg="jsonj fragments generated" rule=ReplaceValue(a) count=1
+level=INFO msg="jsonj pass finished" pass=1 repeat=1 marks=1 bytes_in=8 bytes_out=7
+level=INFO msg="jsonj process finished" bytes_in=8 bytes_out=7 repeats=1
+`
+	if buf.String() != want {
+		t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, buf.String())
+	}
+}
@@ -0,0 +1,198 @@
+// Package httpj post-processes JSON responses of net/http handlers using jsonj.Process.
+package httpj
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cyberstudio/jsonj"
+)
+
+// Config describes middleware behavior
+type Config struct {
+	// Params returns process params for the request, i.e. passes chosen by route.
+	// Response is written as is if ok is false.
+	Params func(r *http.Request) (params jsonj.ProcessParams, ok bool)
+
+	// ErrorHandler writes response if processing fails.
+	// Headers written by handler are kept except Content-Length and Content-Encoding.
+	// DefaultErrorHandler is used if nil.
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+	// Processor processes responses, jsonj.Process is used if nil
+	Processor *jsonj.Processor
+}
+
+// DefaultErrorHandler writes 500 Internal Server Error response
+func DefaultErrorHandler(w http.ResponseWriter, _ *http.Request, _ error) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// Middleware returns wrapper of handlers to process their JSON responses.
+//
+// Response is processed if its Content-Type is application/json or ends with +json,
+// and Content-Encoding is either gzip or missing. Such responses are buffered,
+// Content-Length is set to the size of the processed body and ETag is removed if the body is changed.
+// Other responses are streamed as is.
+func Middleware(cfg Config) func(http.Handler) http.Handler {
+	if cfg.Params == nil {
+		panic("params func is missing")
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = DefaultErrorHandler
+	}
+	run := jsonj.Process
+	if cfg.Processor != nil {
+		run = cfg.Processor.Process
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			params, ok := cfg.Params(r)
+			if !ok || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+			if !rw.wroteHeader || rw.bypass {
+				return
+			}
+
+			body, err := process(r, run, rw.Header().Get("Content-Encoding"), rw.buf.Bytes(), params)
+			if err != nil {
+				w.Header().Del("Content-Length")
+				w.Header().Del("Content-Encoding")
+				cfg.ErrorHandler(w, r, err)
+				return
+			}
+			if !bytes.Equal(body, rw.buf.Bytes()) {
+				w.Header().Del("ETag") // validator of the handler's body
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(rw.status)
+			_, _ = w.Write(body)
+		})
+	}
+}
+
+// processFunc is jsonj.Process or Process method of jsonj.Processor
+type processFunc func(ctx context.Context, input []byte, params jsonj.ProcessParams) ([]byte, error)
+
+// process returns body processed by run and encoded as per Content-Encoding, body is returned if it isn't changed
+func process(
+	r *http.Request,
+	run processFunc,
+	encoding string,
+	body []byte,
+	params jsonj.ProcessParams,
+) ([]byte, error) {
+	if encoding != "gzip" {
+		return run(r.Context(), body, params)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress response: %w", err)
+	}
+	input, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress response: %w", err)
+	}
+	output, err := run(r.Context(), input, params)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(output, input) {
+		return body, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(output); err != nil {
+		return nil, fmt.Errorf("unable to compress response: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress response: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// responseWriter buffers JSON response and writes through others
+type responseWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	bypass      bool // response is written to ResponseWriter as is
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 { // informational headers are written through
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	if !hasBody(status) || !isJSON(w.Header().Get("Content-Type")) || !isSupportedEncoding(w.Header()) {
+		w.bypass = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.bypass {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Flush flushes responses written as is, buffered responses are written when handler returns
+func (w *responseWriter) Flush() {
+	if !w.bypass {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns original ResponseWriter, see http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func hasBody(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// isJSON reports whether content type is application/json or application/*+json
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" ||
+		(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+func isSupportedEncoding(h http.Header) bool {
+	switch h.Get("Content-Encoding") {
+	case "", "identity", "gzip":
+		return true
+	default:
+		return false
+	}
+}
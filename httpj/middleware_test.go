@@ -0,0 +1,239 @@
+package httpj
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/cyberstudio/jsonj"
+)
+
+func TestMiddleware(t *testing.T) {
+	generateURL := func(_ context.Context, iterator jsonj.FragmentIterator, _ interface{}) ([]interface{}, error) {
+		type Output struct {
+			URL string `json:"url"`
+		}
+		var entities []interface{}
+		for iterator.Next() {
+			var id int
+			if err := iterator.BindParams(&id); err != nil {
+				return nil, err
+			}
+			entities = append(entities, Output{URL: "/pets/" + strconv.Itoa(id)})
+		}
+		return entities, nil
+	}
+	params := jsonj.ProcessParams{
+		Passes: []jsonj.Pass{{
+			RuleSet: jsonj.NewRuleSet(jsonj.NewInsertRule("pet_id", "id", generateURL)),
+			Repeats: 1,
+		}},
+	}
+	middleware := Middleware(Config{
+		Params: func(r *http.Request) (jsonj.ProcessParams, bool) {
+			return params, r.URL.Path != "/raw"
+		},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			if err == nil {
+				t.Error("error expected")
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = io.WriteString(w, "processing error")
+		},
+	})
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		encoding    string
+		status      int
+		body        string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:        "json",
+			path:        "/pets",
+			contentType: "application/json; charset=utf-8",
+			status:      http.StatusCreated,
+			body:        `{"pet_id":1}`,
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"id":1,"url":"/pets/1"}`,
+		},
+		{
+			name:        "json suffix",
+			path:        "/pets",
+			contentType: "application/problem+json",
+			status:      http.StatusOK,
+			body:        `{"pet_id":1}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"id":1,"url":"/pets/1"}`,
+		},
+		{
+			name:        "gzip",
+			path:        "/pets",
+			contentType: "application/json",
+			encoding:    "gzip",
+			status:      http.StatusOK,
+			body:        `[{"pet_id":1},{"pet_id":2}]`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `[{"id":1,"url":"/pets/1"},{"id":2,"url":"/pets/2"}]`,
+		},
+		{
+			name:        "not json",
+			path:        "/pets",
+			contentType: "text/plain",
+			status:      http.StatusOK,
+			body:        `{"pet_id":1}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"pet_id":1}`,
+		},
+		{
+			name:        "route without passes",
+			path:        "/raw",
+			contentType: "application/json",
+			status:      http.StatusOK,
+			body:        `{"pet_id":1}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"pet_id":1}`,
+		},
+		{
+			name:        "error",
+			path:        "/pets",
+			contentType: "application/json",
+			status:      http.StatusOK,
+			body:        `{"pet_id":"one"}`,
+			wantStatus:  http.StatusBadGateway,
+			wantBody:    "processing error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				body := []byte(tt.body)
+				if tt.encoding == "gzip" {
+					body = gzipBytes(t, body)
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.WriteHeader(tt.status)
+				_, _ = w.Write(body)
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, http.NoBody))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Unexpected status:\n  expected: %d\n  actual: %d", tt.wantStatus, rec.Code)
+			}
+			body := rec.Body.Bytes()
+			if length := rec.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(len(body)) {
+				t.Errorf("Content-Length %s doesn't match body size %d", length, len(body))
+			}
+			if rec.Header().Get("Content-Encoding") == "gzip" {
+				body = gunzipBytes(t, body)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.wantBody, body)
+			}
+		})
+	}
+
+	t.Run("etag", func(t *testing.T) {
+		for _, tt := range []struct {
+			body     string
+			encoding string
+			want     string
+		}{
+			{body: `{"pet_id":1}`},
+			{body: `{"pet_id":1}`, encoding: "gzip"},
+			{body: `{"id":1}`, want: `"v1"`},
+			{body: `{"id":1}`, encoding: "gzip", want: `"v1"`},
+		} {
+			handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				body := []byte(tt.body)
+				if tt.encoding == "gzip" {
+					body = gzipBytes(t, body)
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("ETag", `"v1"`)
+				_, _ = w.Write(body)
+			}))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+			if etag := rec.Header().Get("ETag"); etag != tt.want {
+				t.Errorf("%s %s: unexpected ETag:\n  expected: %s\n  actual: %s", tt.body, tt.encoding, tt.want, etag)
+			}
+		}
+	})
+
+	t.Run("default error handler", func(t *testing.T) {
+		handler := Middleware(Config{
+			Params: func(*http.Request) (jsonj.ProcessParams, bool) {
+				return params, true
+			},
+		})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"pet_id":"one"}`)
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Unexpected status:\n  expected: %d\n  actual: %d", http.StatusInternalServerError, rec.Code)
+		}
+	})
+
+	t.Run("processor", func(t *testing.T) {
+		handler := Middleware(Config{
+			Params: func(*http.Request) (jsonj.ProcessParams, bool) {
+				return params, true
+			},
+			Processor: jsonj.NewProcessor(jsonj.WithLimits(jsonj.Limits{MaxInputBytes: 5})),
+		})(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"pet_id":1}`)
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Unexpected status:\n  expected: %d\n  actual: %d", http.StatusInternalServerError, rec.Code)
+		}
+	})
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gunzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,79 @@
+package jsonj
+
+import "bytes"
+
+// fragmentFormat describes layout of a fragment written to the output
+type fragmentFormat struct {
+	prefix     string // indentation of the line with mark
+	indent     string // indent unit, fragment is written compact if empty
+	escapeHTML bool   // see json.Encoder.SetEscapeHTML
+	maxOutput  int    // Limits.MaxOutputBytes, fragments aren't written over it if positive
+}
+
+// newFragmentFormat returns format of fragments written to data during a pass
+func newFragmentFormat(data []byte, params *ProcessParams) fragmentFormat {
+	f := fragmentFormat{
+		indent:     params.FragmentIndent,
+		escapeHTML: !params.DisableHTMLEscape,
+		maxOutput:  params.Limits.MaxOutputBytes,
+	}
+	if params.PreserveIndent && f.indent == "" {
+		f.indent = detectIndent(data)
+	}
+	return f
+}
+
+// at returns format of fragment written instead of mark at pos.
+//
+// Fragment is indented at the depth of the line with mark, it's written compact if mark doesn't start its own line.
+func (f fragmentFormat) at(data []byte, pos int) fragmentFormat {
+	if f.indent == "" {
+		return f
+	}
+	prefix, ok := lineIndent(data, pos)
+	if !ok {
+		f.indent = ""
+		return f
+	}
+	f.prefix = prefix
+	return f
+}
+
+// detectIndent returns indent unit of pretty-printed json data.
+//
+// It's the leading whitespace of the first indented line, i.e. "  " for output of json.MarshalIndent(v, "", "  ").
+// It returns empty string for single line data.
+func detectIndent(data []byte) string {
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			return ""
+		}
+		data = data[i+1:]
+		n := 0
+		for n < len(data) && (data[n] == ' ' || data[n] == '\t') {
+			n++
+		}
+		if n > 0 {
+			return string(data[:n])
+		}
+	}
+}
+
+// lineIndent returns leading whitespace of the line if pos is the first non-whitespace char of the line
+func lineIndent(data []byte, pos int) (string, bool) {
+	i := pos
+	for i > 0 && (data[i-1] == ' ' || data[i-1] == '\t') {
+		i--
+	}
+	if i == 0 || (data[i-1] != '\n' && data[i-1] != '\r') {
+		return "", false
+	}
+	return string(data[i:pos]), true
+}
+
+// unindentMembers shifts lines of object written with prefix one indent unit left,
+// so object members get the same indentation as its closing bracket.
+func (f fragmentFormat) unindentMembers(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte("\n"+f.prefix+f.indent), []byte("\n"+f.prefix))
+}
@@ -0,0 +1,174 @@
+package jsonj
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProcess_preserveIndent(t *testing.T) {
+	generateMeta := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		type Output struct {
+			Meta struct {
+				Length int `json:"length"`
+			} `json:"meta"`
+			Tags []string `json:"tags"`
+		}
+		var entities []interface{}
+		for iterator.Next() {
+			var output Output
+			output.Meta.Length = len(iterator.Bytes())
+			output.Tags = []string{"a"}
+			entities = append(entities, output)
+		}
+		return entities, nil
+	}
+	const input = `[
+  {
+    "mark": "value",
+    "id": 1
+  },
+  {
+    "id": 2,
+    "mark": "value"
+  }
+]`
+
+	tests := []struct {
+		name  string
+		rules []*Rule
+		want  string
+	}{
+		{
+			name:  "insert",
+			rules: []*Rule{NewInsertRule("mark", "key", generateMeta)},
+			want: `[
+  {
+    "key": "value",
+    "meta": {
+      "length": 8
+    },
+    "tags": [
+      "a"
+    ],
+    "id": 1
+  },
+  {
+    "id": 2,
+    "key": "value",
+    "meta": {
+      "length": 8
+    },
+    "tags": [
+      "a"
+    ]
+  }
+]`,
+		},
+		{
+			name:  "replace value",
+			rules: []*Rule{NewReplaceValueRule("mark", "key", generateMeta)},
+			want: `[
+  {
+    "key": {
+      "meta": {
+        "length": 8
+      },
+      "tags": [
+        "a"
+      ]
+    },
+    "id": 1
+  },
+  {
+    "id": 2,
+    "key": {
+      "meta": {
+        "length": 8
+      },
+      "tags": [
+        "a"
+      ]
+    }
+  }
+]`,
+		},
+		{
+			name:  "replace",
+			rules: []*Rule{NewReplaceRule("mark", generateMeta)},
+			want: `[
+  {
+    "meta": {
+      "length": 8
+    },
+    "tags": [
+      "a"
+    ],
+    "id": 1
+  },
+  {
+    "id": 2,
+    "meta": {
+      "length": 8
+    },
+    "tags": [
+      "a"
+    ]
+  }
+]`,
+		},
+		{
+			name:  "delete",
+			rules: []*Rule{NewDeleteRule("mark")},
+			want: `[
+  {
+    "id": 1
+  },
+  {
+    "id": 2
+  }
+]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Process(context.Background(), []byte(input), ProcessParams{
+				Passes:         []Pass{{RuleSet: NewRuleSet(tt.rules...), Repeats: 1}},
+				PreserveIndent: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("compact input", func(t *testing.T) {
+		got, err := Process(context.Background(), []byte(`{"mark": "value"}`), ProcessParams{
+			Passes: []Pass{{
+				RuleSet: NewRuleSet(NewInsertRule("mark", "key", generateMeta)),
+				Repeats: 1,
+			}},
+			PreserveIndent: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		const want = `{"key": "value","meta":{"length":8},"tags":["a"]}`
+		if string(got) != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, got)
+		}
+	})
+	t.Run("replace by scalar", func(t *testing.T) {
+		_, err := Process(context.Background(), []byte("{\n  \"mark\": 1,\n  \"b\": 2\n}"), ProcessParams{
+			Passes:         []Pass{{RuleSet: NewRuleSet(NewReplaceRule("mark", Constant(5))), Repeats: 1}},
+			PreserveIndent: true,
+		})
+		const want = "unable to do pass 0: unable to write key-value replacement for mark 'mark': " +
+			"Replace mode suspects object fragment, got 5"
+		if err == nil || err.Error() != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %v", want, err)
+		}
+	})
+}
@@ -6,17 +6,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"reflect"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // RuleSet describes set of Rule to expand raw JSON data.
+// It must not be modified by AddRule during Process.
 type RuleSet struct {
-	rules map[string]*Rule
-	re    *regexp.Regexp
+	rules     map[string]*Rule
+	once      sync.Once // compiles regexps on first use after rules are added
+	re        *regexp.Regexp
+	reEscaped *regexp.Regexp // matches keys with escape sequences too
 }
 
 func NewRuleSet(rules ...*Rule) *RuleSet {
@@ -36,21 +42,36 @@ func (set *RuleSet) AddRule(rule *Rule) {
 		set.rules = make(map[string]*Rule)
 	}
 	set.rules[mark] = rule
-	set.re = nil
+	set.once = sync.Once{} // regexps are compiled again
 }
 
-func (set *RuleSet) regexp() *regexp.Regexp {
-	if set.re != nil {
-		return set.re
-	}
-
+// compile compiles regexps of marks
+func (set *RuleSet) compile() {
 	marks := make([]string, 0, len(set.rules))
 	for m := range set.rules {
-		marks = append(marks, regexp.QuoteMeta(m))
+		if quoted := quoteKey(m); quoted[1:len(quoted)-1] == m {
+			marks = append(marks, regexp.QuoteMeta(m))
+		}
+	}
+	literal := `[^\s\S]` // never matches if every mark needs escaping
+	if len(marks) > 0 {
+		literal = strings.Join(marks, "|")
 	}
 	// determine position of leading comma and whitespace for deletion mode
-	exp := `(,[ \t\n\r]*)?"(` + strings.Join(marks, "|") + `)"[ \t\n\r]*:`
+	exp := `(,[ \t\n\r]*)?"(` + literal + `)"[ \t\n\r]*:`
 	set.re = regexp.MustCompile(exp)
+	// keys with escape sequences are matched separately to be compared with marks by decoded value
+	exp = `(,[ \t\n\r]*)?"(?:(` + literal + `)|((?:[^"\\]*\\.)+[^"\\]*))"[ \t\n\r]*:`
+	set.reEscaped = regexp.MustCompile(exp)
+}
+
+// regexp returns regexp of marks, keys with escape sequences are matched if escaped is set.
+// Regexps are compiled once, so concurrent Process calls only read them after that.
+func (set *RuleSet) regexp(escaped bool) *regexp.Regexp {
+	set.once.Do(set.compile)
+	if escaped {
+		return set.reEscaped
+	}
 	return set.re
 }
 
@@ -63,6 +84,12 @@ const (
 	ModeDelete
 	ModeReplace
 	ModeReplaceValue
+	ModeRedact
+	ModeWrap
+	ModeUnwrap
+	ModeMapElements
+	ModeFilterElements
+	ModeAppendElements
 )
 
 func (i RuleMode) String() string {
@@ -77,6 +104,18 @@ func (i RuleMode) String() string {
 		return "Insert"
 	case ModeDelete:
 		return "Delete"
+	case ModeRedact:
+		return "Redact"
+	case ModeWrap:
+		return "Wrap"
+	case ModeUnwrap:
+		return "Unwrap"
+	case ModeMapElements:
+		return "MapElements"
+	case ModeFilterElements:
+		return "FilterElements"
+	case ModeAppendElements:
+		return "AppendElements"
 	default:
 		panic("unknown mode value")
 	}
@@ -93,12 +132,46 @@ type Rule struct {
 	preparedKey string   // key with quotes
 	mode        RuleMode // replace, insert, delete?
 	genBatch    GenerateFragmentBatchFunc
+	when        Predicate // rule applies to all values if nil
+	element     Predicate // elements kept by ModeFilterElements
 }
 
 func (r *Rule) String() string {
 	return fmt.Sprintf("%s(%s)", r.mode, r.mark)
 }
 
+// When makes the rule apply only to values matching predicate,
+// other values are written through unchanged and aren't passed to the generator.
+// Predicates of several calls must all match.
+// It returns the rule to be used in NewRuleSet arguments.
+func (r *Rule) When(predicate Predicate) *Rule {
+	if predicate == nil {
+		panic("predicate is missing")
+	}
+	if when := r.when; when != nil {
+		r.when = func(raw []byte) bool { return when(raw) && predicate(raw) }
+	} else {
+		r.when = predicate
+	}
+	return r
+}
+
+// keepsMark reports whether the rule writes its mark again, such rules apply on the first repeat of a pass only,
+// so the next repeats don't rewrite values they've produced
+func (r *Rule) keepsMark() bool {
+	switch r.mode {
+	case ModeRedact, ModeWrap, ModeMapElements, ModeFilterElements, ModeAppendElements:
+		return true
+	default:
+		return false
+	}
+}
+
+// matches reports whether the rule applies to raw json value
+func (r *Rule) matches(value []byte) bool {
+	return r.when == nil || r.when(bytes.TrimSpace(value))
+}
+
 func NewInsertRule(mark, key string, batchFunc GenerateFragmentBatchFunc) *Rule {
 	return NewRule(ModeInsert, mark, key, batchFunc)
 }
@@ -115,6 +188,18 @@ func NewDeleteRule(mark string) *Rule {
 	return NewRule(ModeDelete, mark, "", nil)
 }
 
+// NewWrapRule creates rule wrapping value of mark into object with the field,
+// members generated by batchFunc are appended to the object like Insert mode does
+func NewWrapRule(mark, field string, batchFunc GenerateFragmentBatchFunc) *Rule {
+	return NewRule(ModeWrap, mark, field, batchFunc)
+}
+
+// NewUnwrapRule creates rule replacing mark by members of its object value,
+// values other than objects are kept
+func NewUnwrapRule(mark string) *Rule {
+	return NewRule(ModeUnwrap, mark, "", nil)
+}
+
 // NewRule creates new rule using specified params
 // mark is searchable field and key is new key value that replaces mark
 // For example, mark is '_uuid_', key is 'uuid'
@@ -125,7 +210,7 @@ func NewRule(mode RuleMode, mark, key string, batchFunc GenerateFragmentBatchFun
 	if mark == "" {
 		panic("mark is missing")
 	}
-	if mode != ModeReplaceValue && mark == key {
+	if mode != ModeReplaceValue && mode != ModeWrap && mark == key {
 		panic("key should not be equal mark")
 	}
 	if mode == ModeDelete {
@@ -136,15 +221,48 @@ func NewRule(mode RuleMode, mark, key string, batchFunc GenerateFragmentBatchFun
 			genBatch:    EmptyFragmentsGenerator,
 		}
 	}
+	if mode == ModeUnwrap {
+		return &Rule{
+			mark:        mark,
+			preparedKey: "",
+			mode:        mode,
+			genBatch:    rawValueGenerator,
+			when:        isObject,
+		}
+	}
+	if mode == ModeFilterElements {
+		panic("filter elements rule is created by NewFilterElementsRule")
+	}
+	if mode == ModeMapElements || mode == ModeAppendElements {
+		if key != "" {
+			panic("key isn't used by " + mode.String() + " mode")
+		}
+		if batchFunc == nil {
+			panic("batchFunc is missing")
+		}
+		return &Rule{
+			mark:        mark,
+			preparedKey: "",
+			mode:        mode,
+			genBatch:    batchFunc,
+			when:        isArray,
+		}
+	}
 
 	if batchFunc == nil {
 		panic("batchFunc is missing")
 	}
 
+	if mode == ModeRedact {
+		if key != "" {
+			panic("key isn't used by Redact mode")
+		}
+		key = mark // redacted value keeps its key
+	}
 	if mode != ModeReplace && key == "" {
 		panic("key is missing")
 	}
-	key = `"` + strings.ReplaceAll(key, `"`, `\"`) + `"`
+	key = quoteKey(key)
 	return &Rule{
 		mark:        mark,
 		preparedKey: key,
@@ -153,6 +271,19 @@ func NewRule(mode RuleMode, mark, key string, batchFunc GenerateFragmentBatchFun
 	}
 }
 
+// quoteKey returns json string of key escaped as per RFC 8259, see appendJSONString
+func quoteKey(key string) string {
+	return string(appendJSONString(nil, key))
+}
+
+// KeyValue is fragment choosing key of the mark per occurrence,
+// it's honored by ModeReplaceValue and ModeInsert instead of rule's key.
+// Value is written as fragment of the mode.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
 // FragmentIterator allows fragments generators func iterates over json data to be replaced during a pass.
 // See GenerateFragmentBatchFunc implementation examples.
 type FragmentIterator interface {
@@ -176,30 +307,220 @@ type GenerateFragmentBatchFunc func(ctx context.Context, marks FragmentIterator,
 type ProcessParams struct {
 	Passes []Pass // the order of passes is important, see children depths at pet_api_example_test.go
 	Params interface{}
+
+	// PreserveIndent writes fragments indented like pretty-printed input,
+	// each inserted member on its own line at the mark's depth.
+	PreserveIndent bool
+	// FragmentIndent is indent unit of fragments, fragments are written compact if empty.
+	// Fragments are indented at the mark's depth and written compact if the mark doesn't start its own line.
+	// It overrides indent unit detected in input if PreserveIndent is set.
+	FragmentIndent string
+	// DisableHTMLEscape writes '<', '>' and '&' of fragments as is, see json.Encoder.SetEscapeHTML
+	DisableHTMLEscape bool
+
+	DuplicateKeys DuplicateKeysPolicy // output validation, keys aren't checked by default
+	// ValidateInput validates the whole input before passes, only values of marks are validated by default
+	ValidateInput bool
+
+	Output       OutputFormat // formatting of the whole output, input formatting is kept by default
+	OutputIndent string       // indent unit for OutputIndent, two spaces if empty
+
+	// Trace records rewrites of every pass repeat if not nil, it slows processing down
+	Trace *Trace
+	// Patch records JSON Patch of rewrites of every pass repeat if not nil, output formatting isn't recorded.
+	// DuplicateKeys shouldn't resolve duplicate keys.
+	Patch *Patch
+	// SourceMap maps output to input if not nil, Output should be OutputAsIs
+	SourceMap *SourceMap
+	// Hooks are notified of passes and generator calls if not nil, i.e. to collect metrics
+	Hooks Hooks
+
+	Limits Limits // resources aren't limited by default
 }
 
-// Process passes data changes using ProcessParams
-func Process(ctx context.Context, input []byte, params ProcessParams) ([]byte, error) {
-	if len(input) <= 2 { // quickfix for [], {}
-		return input, nil
+// ErrInvalidJSON is wrapped by errors of Process reporting invalid json input
+var ErrInvalidJSON = errors.New("invalid json")
+
+// Process passes data changes using ProcessParams.
+// Limits and Hooks of the processor are used if params don't set them.
+//
+// Output is allocated for every call unless it's input itself, see AppendProcess to reuse memory.
+func (p *Processor) Process(ctx context.Context, input []byte, params ProcessParams) ([]byte, error) {
+	result, err := p.run(ctx, input, p.withDefaults(params))
+	if err != nil {
+		return nil, err
 	}
-	if len(params.Passes) == 0 {
+	if result == nil {
 		return input, nil
 	}
+	output := bytes.Clone(result.Bytes())
+	p.freeBuffer(result)
+	return output, nil
+}
+
+// AppendProcess appends output of Process to dst and returns the extended buffer.
+// All buffers used by processing are returned to the pool.
+func (p *Processor) AppendProcess(ctx context.Context, dst, input []byte, params ProcessParams) ([]byte, error) {
+	result, err := p.run(ctx, input, p.withDefaults(params))
+	if err != nil {
+		return dst, err
+	}
+	if result == nil {
+		return append(dst, input...), nil
+	}
+	dst = append(dst, result.Bytes()...)
+	p.freeBuffer(result)
+	return dst, nil
+}
+
+// ProcessTo writes output of Process to w, nothing is written on error.
+// All buffers used by processing are returned to the pool.
+func (p *Processor) ProcessTo(ctx context.Context, w *bytes.Buffer, input []byte, params ProcessParams) error {
+	result, err := p.run(ctx, input, p.withDefaults(params))
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		w.Write(input)
+		return nil
+	}
+	w.Write(result.Bytes())
+	p.freeBuffer(result)
+	return nil
+}
+
+// run returns pool buffer of output to be freed by caller, it's nil if output is input itself.
+// Other buffers are returned to the pool. Limits and Hooks of the processor aren't applied by run.
+func (p *Processor) run(ctx context.Context, input []byte, params ProcessParams) (result *bytes.Buffer, err error) {
+	stats := ProcessStats{BytesIn: len(input)}
+	if params.Hooks != nil {
+		defer func() {
+			stats.BytesOut = len(input)
+			if result != nil {
+				stats.BytesOut = result.Len()
+			}
+			if err != nil {
+				stats.BytesOut = 0
+			}
+			params.Hooks.OnDone(stats, err)
+		}()
+	}
+	resolvesDuplicates := params.DuplicateKeys == DuplicateKeysKeepFirst || params.DuplicateKeys == DuplicateKeysKeepLast
+	if params.Patch != nil && resolvesDuplicates {
+		// adding existing key replaces its value, so patch can't keep duplicate keys to be resolved
+		return nil, errors.New("patch of resolved duplicate keys isn't supported")
+	}
+	if params.SourceMap != nil {
+		if params.Output != OutputAsIs {
+			return nil, errors.New("source map of formatted output isn't supported")
+		}
+		params.SourceMap.reset(len(input))
+	}
+
+	defer recoverInvalidJSON(&err) // input is scanned by limits and passes
+	if err := params.Limits.checkInput(input); err != nil {
+		return nil, err
+	}
+	if params.ValidateInput {
+		validateJSON(input)
+	}
+	if len(input) <= 2 { // quickfix for [], {}
+		return nil, params.Limits.checkOutput(len(input))
+	}
+	if len(params.Passes) == 0 && params.DuplicateKeys == DuplicateKeysAllow && params.Output == OutputAsIs {
+		return nil, params.Limits.checkOutput(len(input))
+	}
 
-	data, buf := bytes.NewBuffer(input), newBytesBuffer(len(input))
+	// input is copied, so buffers swapped after a pass never write to input memory
+	data, buf := p.newBuffer(len(input), &params.Limits), p.newBuffer(len(input), &params.Limits)
+	data.Write(input)
+	defer func() {
+		p.freeBuffer(buf)
+		if result == nil { // error is returned or scanner panics
+			p.freeBuffer(data)
+		}
+	}()
 
-	for _, pass := range params.Passes {
+	for n, pass := range params.Passes {
 		for i := 0; i < pass.Repeats; i++ {
-			if err := doPassBatch(ctx, buf, data.Bytes(), pass.RuleSet, params.Params); err != nil {
+			if err := ctx.Err(); err != nil {
+				return nil, interruptedError(n, i, err)
+			}
+			if params.Trace != nil {
+				params.Trace.Repeats = append(params.Trace.Repeats, TraceRepeat{Pass: n + 1, Repeat: i + 1})
+			}
+			if params.SourceMap != nil {
+				params.SourceMap.pass, params.SourceMap.repeat = n+1, i+1
+			}
+			if params.Hooks != nil {
+				params.Hooks.OnPassStart(n+1, i+1)
+			}
+			marks, err := doPassBatch(ctx, buf, data.Bytes(), pass.RuleSet, i+1, &params)
+			stats.Repeats++
+			if params.Hooks != nil {
+				params.Hooks.OnPassEnd(PassStats{
+					Pass:     n + 1,
+					Repeat:   i + 1,
+					Marks:    marks,
+					BytesIn:  data.Len(),
+					BytesOut: buf.Len(),
+				}, err)
+			}
+			if err == nil {
+				err = params.Limits.checkOutput(buf.Len())
+			}
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+					return nil, interruptedError(n, i, err)
+				}
 				return nil, fmt.Errorf("unable to do pass %d: %w", i, err)
 			}
 			data, buf = buf, data
 			buf.Reset()
 		}
 	}
-	freeBuf(buf)
-	return data.Bytes(), nil
+	if params.DuplicateKeys != DuplicateKeysAllow {
+		var sources *sourceMapper
+		if params.SourceMap != nil {
+			sources = params.SourceMap.newMapper()
+		}
+		if err := resolveDuplicateKeys(buf, data.Bytes(), params.DuplicateKeys, sources); err != nil {
+			return nil, fmt.Errorf("unable to validate output: %w", err)
+		}
+		if sources != nil {
+			params.SourceMap.compose(sources.spans)
+		}
+		data, buf = buf, data
+		buf.Reset()
+	}
+	if params.Output != OutputAsIs {
+		if err := formatOutput(buf, data.Bytes(), params.Output, params.OutputIndent); err != nil {
+			return nil, fmt.Errorf("unable to format output: %w", err)
+		}
+		if err := params.Limits.checkOutput(buf.Len()); err != nil {
+			return nil, err
+		}
+		data, buf = buf, data
+	}
+	return data, nil
+}
+
+// interruptedError wraps error of done context with pass and repeat numbers starting from 1
+func interruptedError(pass, repeat int, err error) error {
+	return fmt.Errorf("pass %d, repeat %d interrupted: %w", pass+1, repeat+1, err)
+}
+
+// recoverInvalidJSON turns panic of json scanner into error wrapping ErrInvalidJSON, other panics are repeated
+func recoverInvalidJSON(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	msg, ok := r.(string)
+	if !ok || !strings.HasPrefix(msg, ErrInvalidJSON.Error()) {
+		panic(r)
+	}
+	*err = fmt.Errorf("%w%s", ErrInvalidJSON, strings.TrimPrefix(msg, ErrInvalidJSON.Error()))
 }
 
 type fragEntry struct {
@@ -209,57 +530,218 @@ type fragEntry struct {
 	argsPos  int
 	endPos   int
 	fragment interface{}
+	key      string // quoted key chosen by KeyValue fragment, rule's preparedKey is used if empty
 }
 
 func (e fragEntry) String() string {
 	return fmt.Sprintf("%s at position %d", e.rule.String(), e.markPos)
 }
 
-// writeForInsertMode writes FRAGMENT marshaled to json.
+// setFragment sets generated fragment, KeyValue fragment sets key of the entry
+func (e *fragEntry) setFragment(fragment interface{}) {
+	kv, ok := fragment.(KeyValue)
+	if !ok {
+		if p, isPtr := fragment.(*KeyValue); isPtr && p != nil {
+			kv, ok = *p, true
+		}
+	}
+	if !ok {
+		e.fragment = fragment
+		return
+	}
+	if e.rule.mode != ModeReplaceValue && e.rule.mode != ModeInsert {
+		panic(e.rule.mode.String() + " mode doesn't support KeyValue fragment: " + e.String())
+	}
+	if kv.Key == "" {
+		panic("KeyValue key is missing: " + e.String())
+	}
+	e.key = quoteKey(kv.Key)
+	e.fragment = kv.Value
+}
+
+// preparedKey returns quoted key written instead of the mark
+func (e *fragEntry) preparedKey() string {
+	if e.key != "" {
+		return e.key
+	}
+	return e.rule.preparedKey
+}
+
+// writeForInsertMode writes FRAGMENT marshaled to json object.
+// It returns error if fragment isn't marshaled to object, i.e. it's nil.
 //
 // Format: `,<FRAGMENT>`
-func (e *fragEntry) writeForInsertMode(b *bytes.Buffer) error {
-	v := reflect.Indirect(reflect.ValueOf(e.fragment))
-	if v.Kind() != reflect.Struct {
-		panic("insert mode suspects Struct fragment, got " + v.String() + ": " + e.String())
-	}
+func (e *fragEntry) writeForInsertMode(b *bytes.Buffer, f fragmentFormat) error {
 	l := b.Len()
-	if err := e.writeFragment(b); err != nil {
+	if err := e.writeFragment(b, f); err != nil {
 		return err
 	}
 	data := b.Bytes()[l:b.Len()]
+	if data[0] != '{' {
+		return fmt.Errorf("%s mode suspects object fragment, got %s", e.rule.mode, data)
+	}
 	if bytes.Equal(data, []byte(`{}`)) {
 		b.Truncate(l)
 		return nil
 	}
+	if f.indent != "" {
+		// write each member on its own line at the mark's depth:
+		// `,\n<prefix><FRAGMENT>`
+		data = f.unindentMembers(data)
+		b.Truncate(l)
+		b.Write(data[:len(data)-len(f.prefix)-2]) // trim `\n<prefix>}`
+		b.Bytes()[l] = ','
+		return nil
+	}
 	// trim brackets
 	data[0] = ','
 	b.Truncate(b.Len() - 1)
 	return nil
 }
 
-func (e *fragEntry) writeForReplaceValueMode(buf *bytes.Buffer) error {
-	return e.writeFragment(buf)
+// writeForWrapMode writes value at valuePos of data wrapped into object with FRAGMENT members.
+//
+// Format: `{<preparedKey>:<value>,<FRAGMENT>}`
+func (e *fragEntry) writeForWrapMode(
+	b *bytes.Buffer,
+	data []byte,
+	valuePos int,
+	f fragmentFormat,
+	sources *sourceMapper,
+) error {
+	if f.indent == "" {
+		b.WriteString("{" + e.rule.preparedKey + ":")
+		sources.write(b, data, valuePos, e.endPos)
+		if err := e.writeForInsertMode(b, f); err != nil {
+			return err
+		}
+		b.WriteByte('}')
+		return nil
+	}
+	// members are written one indent unit deeper than the mark
+	inner := f
+	inner.prefix += f.indent
+	b.WriteString("{\n" + inner.prefix + e.rule.preparedKey + ": ")
+	sources.writeReplacing(b, data, valuePos, e.endPos, "\n", "\n"+f.indent)
+	if err := e.writeForInsertMode(b, inner); err != nil {
+		return err
+	}
+	b.WriteString("\n" + f.prefix + "}")
+	return nil
 }
 
-func (e *fragEntry) writeForReplaceMode(b *bytes.Buffer) (int, error) {
+// writeForAppendMode writes data from pos to the end of the last element of array value
+// followed by FRAGMENT elements, it returns position of data written next.
+// Nothing is appended for null fragment, it returns error if fragment isn't marshaled to array.
+//
+// Format: `,<FRAGMENT>`
+func (e *fragEntry) writeForAppendMode(
+	b *bytes.Buffer,
+	data []byte,
+	pos int,
+	f fragmentFormat,
+	sources *sourceMapper,
+) (int, error) {
 	l := b.Len()
-	if err := e.writeFragment(b); err != nil {
+	if err := e.writeFragment(b, f); err != nil {
+		return 0, err
+	}
+	fragment := bytes.Clone(b.Bytes()[l:]) // data up to the last element is written first
+	b.Truncate(l)
+	if fragment[0] != '[' {
+		if bytes.Equal(fragment, nullLiteral) {
+			return pos, nil
+		}
+		return 0, fmt.Errorf("%s mode suspects array fragment, got %s", e.rule.mode, fragment)
+	}
+	if bytes.Equal(fragment, []byte("[]")) {
+		return pos, nil
+	}
+
+	start := skipSpaces(data, e.argsPos)
+	elements := arrayElements(data, start)
+	anchor := start + 1 // position after opening bracket or the last element
+	if len(elements) > 0 {
+		anchor = elements[len(elements)-1].endPos
+	}
+	sources.write(b, data, pos, anchor)
+	if len(elements) > 0 {
+		b.WriteByte(',')
+	}
+	if f.indent == "" {
+		b.Write(fragment[1 : len(fragment)-1]) // trim brackets
+		return anchor, nil
+	}
+	// elements are written at the mark's depth plus one indent unit,
+	// trim `[` and `\n<prefix>]`: `\n<prefix><indent><FRAGMENT>`
+	b.Write(fragment[1 : len(fragment)-len(f.prefix)-2])
+	if len(elements) == 0 {
+		b.WriteString("\n" + f.prefix)
+		return e.endPos - 1, nil // skip spaces of empty array
+	}
+	return anchor, nil
+}
+
+// writeForUnwrapMode writes members of object value as is, they're shifted one indent unit left
+// if indentation is preserved.
+//
+// Format: `<MEMBERS>`
+func (e *fragEntry) writeForUnwrapMode(b *bytes.Buffer, data []byte, f fragmentFormat, sources *sourceMapper) {
+	start := skipSpaces(data, skipSpaces(data, e.argsPos)+1)  // after opening bracket
+	end := len(bytes.TrimRight(data[:e.endPos-1], " \t\r\n")) // before closing bracket
+	if f.indent == "" {
+		sources.write(b, data, start, end)
+		return
+	}
+	sources.writeReplacing(b, data, start, end, "\n"+f.prefix+f.indent, "\n"+f.prefix)
+}
+
+func (e *fragEntry) writeForReplaceValueMode(buf *bytes.Buffer, f fragmentFormat) error {
+	return e.writeFragment(buf, f)
+}
+
+func (e *fragEntry) writeForReplaceMode(b *bytes.Buffer, f fragmentFormat) (int, error) {
+	l := b.Len()
+	if err := e.writeFragment(b, f); err != nil {
 		return 0, err
 	}
 	data := b.Bytes()[l:b.Len()]
+	if data[0] != '{' {
+		return 0, fmt.Errorf("%s mode suspects object fragment, got %s", e.rule.mode, data)
+	}
 	if bytes.Equal(data, []byte(`{}`)) {
 		b.Truncate(l)
 		return 0, nil
 	}
+	if f.indent != "" {
+		// the mark's indentation is already written
+		data = f.unindentMembers(data)
+		data = data[len(f.prefix)+2 : len(data)-len(f.prefix)-2] // trim `{\n<prefix>` and `\n<prefix>}`
+		b.Truncate(l)
+		b.Write(data)
+		return len(data), nil
+	}
 	// trim brackets
 	data[0] = ' '
 	b.Truncate(b.Len() - 1)
 	return len(data) - 1, nil
 }
 
-func (e *fragEntry) writeFragment(b *bytes.Buffer) error {
-	if err := json.NewEncoder(b).Encode(e.fragment); err != nil {
+func (e *fragEntry) writeFragment(b *bytes.Buffer, f fragmentFormat) error {
+	var w io.Writer = b
+	if f.maxOutput > 0 {
+		w = limitedWriter{b: b, max: f.maxOutput}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(f.escapeHTML)
+	if f.indent != "" {
+		enc.SetIndent(f.prefix, f.indent)
+	}
+	if err := enc.Encode(e.fragment); err != nil {
+		var limitErr *LimitExceededError
+		if errors.As(err, &limitErr) {
+			return err
+		}
 		return fmt.Errorf("unable to encode fragment '%s': %v", e.fragment, err)
 	}
 	ptr := &b.Bytes()[b.Len()-1]
@@ -308,216 +790,418 @@ func (iter *fragEntryListIter) Bytes() []byte {
 	return iter.data[entry.argsPos:entry.endPos]
 }
 
-// iterateMarks iterates json data using RuleSet regexp like `(,[ \n\r\t]*)?"(mark1|mark2|mark3)"[ \n\r\t]*:`
+// iterateMarks iterates json data using RuleSet regexp like `(,[ \n\r\t]*)?"(mark1|mark2|mark3)"[ \n\r\t]*:`.
+// Values of marks are skipped unless callback returns false, then iteration continues inside the value.
+// It returns ctx.Err() if ctx is done during iteration.
 func iterateMarks(
+	ctx context.Context,
 	data []byte,
-	re *regexp.Regexp,
-	callback func(mark []byte, pos, valuePos, endPos, commaPos int),
-) {
+	set *RuleSet,
+	callback func(rule *Rule, pos, valuePos, endPos, commaPos int) bool,
+) error {
+	// keys can't contain escape sequences if data has no backslashes, simpler regexp is faster
+	re := set.regexp(bytes.IndexByte(data, '\\') != -1)
+	checker := ctxChecker{ctx: ctx}
 	i := 0
 	for {
+		if err := checker.check(i); err != nil {
+			return err
+		}
 		// FindSubMatchIndex indexes returns indexes array:
 		// ,   "key" : "value"
 		// ^  ^ ^ ^  ^
 		// 0  ^ ^ ^  1
 		// 2  3 ^ ^
 		//      4 5
+		// indexes 4 and 5 are -1 for keys containing escape sequences, 6 and 7 are their indexes
 		loc := re.FindSubmatchIndex(data[i:])
 		if loc == nil {
-			break
+			return nil
 		}
 		commaPos := -1
 		if loc[2] != -1 { // prefix comma exists
 			commaPos = i + loc[2]
 		}
-		markPos := i + loc[4] - 1         // position of "key" starts
-		mark := data[i+loc[4] : i+loc[5]] // key
-		i += loc[1]                       // position of "key": ends
+		var rule *Rule
+		if loc[4] != -1 {
+			mark := data[i+loc[4] : i+loc[5]] // key
+			var ok bool
+			if rule, ok = set.rules[string(mark)]; !ok {
+				panic("none rule specified for mark: " + string(mark))
+			}
+		} else {
+			loc[4], loc[5] = loc[6], loc[7]
+			var mark string
+			if err := json.Unmarshal(data[i+loc[4]-1:i+loc[5]+1], &mark); err == nil {
+				rule = set.rules[mark]
+			}
+		}
+		markPos := i + loc[4] - 1 // position of "key" starts
+		i += loc[1]               // position of "key": ends
+		if rule == nil {
+			continue // escaped key isn't a mark
+		}
 		argsPos := i
 		i += findJSONFragmentEnd(data[i:])
 		endPos := i
 
-		callback(mark, markPos, argsPos, endPos, commaPos)
+		if !callback(rule, markPos, argsPos, endPos, commaPos) {
+			i = argsPos
+		}
 	}
 }
 
-func doPassBatch(ctx context.Context, buf *bytes.Buffer, data []byte, set *RuleSet, flags interface{}) error {
-	var fragments []*fragEntry
+// Match describes mark of RuleSet found in json data
+type Match struct {
+	Rule  *Rule
+	Pos   int    // position of mark
+	Value []byte // raw json value of mark
+}
+
+// FindMarks returns marks found in json data in order of their positions.
+//
+// Values of found marks are skipped, so it returns the same marks as the first repeat of a pass processes.
+// It returns error wrapping ErrInvalidJSON if data isn't valid json.
+func (set *RuleSet) FindMarks(data []byte) (_ []Match, err error) {
+	defer recoverInvalidJSON(&err)
+	validateJSON(data)
+	var matches []Match
+	_ = iterateMarks(context.Background(), data, set, func(rule *Rule, pos, valuePos, endPos, _ int) bool {
+		if !rule.matches(data[valuePos:endPos]) {
+			return false
+		}
+		matches = append(matches, Match{
+			Rule:  rule,
+			Pos:   pos,
+			Value: data[skipSpaces(data, valuePos):endPos],
+		})
+		return true
+	})
+	return matches, nil
+}
+
+// doPassBatch writes data processed by a pass repeat to buf, it returns number of rewritten marks and elements
+func doPassBatch(
+	ctx context.Context,
+	buf *bytes.Buffer,
+	data []byte,
+	set *RuleSet,
+	repeat int,
+	params *ProcessParams,
+) (int, error) {
+	var (
+		fragments []*fragEntry
+		rules     []*Rule // rules in order of their first marks
+	)
 	entriesPerRule := make(map[*Rule][]*fragEntry)
 	const initialEntryCount = 32
 
 	// group marks by rules to process their batches
-	iterateMarks(data, set.regexp(), func(mark []byte, pos, valuePos, endPos, commaPos int) {
-		rule, ok := set.rules[string(mark)]
-		if !ok {
-			panic("none rule specified for mark: " + string(mark))
-		}
-		n := len(fragments)
-		fragments = append(fragments, &fragEntry{
-			rule:     rule,
-			commaPos: commaPos,
-			markPos:  pos,
-			argsPos:  valuePos,
-			endPos:   endPos,
-		})
-		entries := entriesPerRule[rule]
-		if entries == nil {
-			entries = make([]*fragEntry, 0, initialEntryCount)
+	marks, maxMarks := 0, params.Limits.MaxMarks
+	err := iterateMarks(ctx, data, set, func(rule *Rule, pos, valuePos, endPos, commaPos int) bool {
+		if (repeat > 1 && rule.keepsMark()) || !rule.matches(data[valuePos:endPos]) {
+			return false // the value is written through, marks inside it are processed
+		}
+		if marks++; maxMarks > 0 && marks > maxMarks {
+			return true // marks are skipped to report the error
 		}
-		entriesPerRule[rule] = append(entries, fragments[n])
+		add := func(entry *fragEntry) {
+			fragments = append(fragments, entry)
+			entries := entriesPerRule[rule]
+			if entries == nil {
+				entries = make([]*fragEntry, 0, initialEntryCount)
+				rules = append(rules, rule)
+			}
+			entriesPerRule[rule] = append(entries, entry)
+		}
+		switch rule.mode {
+		case ModeMapElements, ModeFilterElements:
+			// each element is processed by its own entry
+			for _, elem := range arrayElements(data, skipSpaces(data, valuePos)) {
+				if rule.mode == ModeFilterElements && rule.element(data[elem.valuePos:elem.endPos]) {
+					continue // kept element
+				}
+				add(&fragEntry{
+					rule:     rule,
+					commaPos: elem.commaPos,
+					markPos:  elem.valuePos,
+					argsPos:  elem.valuePos,
+					endPos:   elem.endPos,
+				})
+			}
+		default:
+			add(&fragEntry{
+				rule:     rule,
+				commaPos: commaPos,
+				markPos:  pos,
+				argsPos:  valuePos,
+				endPos:   endPos,
+			})
+		}
+		return true
 	})
+	if err != nil {
+		return 0, err
+	}
+	if maxMarks > 0 && marks > maxMarks {
+		return 0, &LimitExceededError{Limit: "MaxMarks", Max: maxMarks, Value: marks}
+	}
 	if len(entriesPerRule) == 0 {
 		buf.Write(data)
-		return nil
+		return 0, nil
 	}
 
 	// generate new fragments of each fragEntry
-	for rule, list := range entriesPerRule {
+	for _, rule := range rules {
+		list := entriesPerRule[rule]
 		iter := newFragEntryListIter(list, data)
-		result, err := rule.genBatch(ctx, iter, flags)
+		start := time.Now()
+		result, err := rule.genBatch(ctx, iter, params.Params)
+		if params.Trace != nil || params.Hooks != nil {
+			d := time.Since(start)
+			if params.Trace != nil {
+				params.Trace.addCall(rule, len(list), d)
+			}
+			if params.Hooks != nil {
+				params.Hooks.OnGenerate(rule, len(list), d, err)
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("fragments generation error for rule '%s': %w", rule, err)
+			return 0, fmt.Errorf("fragments generation error for rule '%s': %w", rule, err)
 		}
 		if len(list) != len(result) {
 			panic(fmt.Sprintf("unexpected case: %d != %d", len(list), len(result)))
 		}
 		for i := range list {
-			list[i].fragment = result[i]
+			list[i].setFragment(result[i])
 		}
 	}
+	if params.Trace != nil {
+		params.Trace.addRewrites(data, fragments)
+	}
 
-	return expandDataFragments(buf, data, fragments)
+	var sources *sourceMapper
+	if params.SourceMap != nil {
+		sources = params.SourceMap.newMapper()
+	}
+	if err := expandDataFragments(ctx, buf, data, fragments, newFragmentFormat(data, params), sources); err != nil {
+		return 0, err
+	}
+	if sources != nil {
+		params.SourceMap.compose(sources.spans)
+	}
+	if params.Patch != nil {
+		if err := params.Patch.addOperations(data, fragments, !params.DisableHTMLEscape); err != nil {
+			return 0, fmt.Errorf("unable to record patch: %w", err)
+		}
+	}
+	return len(fragments), nil
 }
 
-// BufferSizeRatio grows initial buffer size depends on input size
-var BufferSizeRatio = 3
+const (
+	ctxCheckCount = 256     // marks or fragments between context checks
+	ctxCheckBytes = 1 << 16 // data bytes between context checks
+)
 
-func newBytesBuffer(inputSize int) *bytes.Buffer {
-	buf, ok := bytesBufferPool.Get().(*bytes.Buffer)
-	if !ok {
-		buf = bytes.NewBuffer(make([]byte, 0, inputSize*BufferSizeRatio))
-	} else {
-		buf.Grow(inputSize * BufferSizeRatio)
-	}
-	return buf
+// ctxChecker checks context periodically while data is scanned or written,
+// it's cheaper than ctx.Err() locking mutex of cancelable context for every mark
+type ctxChecker struct {
+	ctx     context.Context
+	count   int
+	lastPos int
 }
 
-func freeBuf(buf *bytes.Buffer) {
-	if buf.Cap() > MaxBufferSize {
-		return
+// check returns ctx.Err() every ctxCheckCount calls or ctxCheckBytes of data after the last check
+func (c *ctxChecker) check(pos int) error {
+	if c.count++; c.count < ctxCheckCount && pos-c.lastPos < ctxCheckBytes {
+		return nil
 	}
-	buf.Reset()
-	bytesBufferPool.Put(buf)
+	c.count, c.lastPos = 0, pos
+	return c.ctx.Err()
 }
 
-const MB = 1 << 20
-
-var (
-	// bytesBufferPool keeps bytes buffers to be reused to decrease memory allocations
-	bytesBufferPool sync.Pool
-
-	// MaxBufferSize limits pool buffers size.
-	// Remember to increase if output json expected to be more
-	MaxBufferSize = 5 * MB
-)
-
-// expandDataFragments returns merged old data and new fragments
-func expandDataFragments(b *bytes.Buffer, data []byte, fragments []*fragEntry) error {
+// expandDataFragments returns merged old data and new fragments.
+//
+// Fragments are encoded as per format, see fragmentFormat.
+func expandDataFragments(
+	ctx context.Context,
+	b *bytes.Buffer,
+	data []byte,
+	fragments []*fragEntry,
+	format fragmentFormat,
+	sources *sourceMapper,
+) error {
 	var pos int
 
+	checker := ctxChecker{ctx: ctx}
 	for _, frag := range fragments {
+		if err := checker.check(frag.markPos); err != nil {
+			return err
+		}
+		if err := (Limits{MaxOutputBytes: format.maxOutput}).checkOutput(b.Len()); err != nil {
+			return err // data written before the fragment exceeds the limit
+		}
+		sources.next(frag, b.Len())
+		f := format.at(data, frag.markPos)
 		switch mode := frag.rule.mode; mode {
-		case ModeReplaceValue:
+		case ModeReplaceValue, ModeRedact:
 			// ModeReplaceValue writes new fragment over old value:
 			//  {
 			//    "<preparedKey>": <FRAGMENT>
 			//  }
-			b.Write(data[pos:frag.markPos])
+			sources.write(b, data, pos, frag.markPos)
 			pos = frag.endPos
-			b.WriteString(frag.rule.preparedKey + `:`) // writes `"<preparedKey>":`
-			err := frag.writeForReplaceValueMode(b)    // writes <FRAGMENT>
+			b.WriteString(frag.preparedKey() + `:`) // writes `"<preparedKey>":`
+			if f.indent != "" {
+				sources.write(b, data, frag.argsPos, skipSpaces(data, frag.argsPos)) // keeps space before value
+			}
+			err := frag.writeForReplaceValueMode(b, f) // writes <FRAGMENT>
 			if err != nil {
-				return fmt.Errorf("unable to write value replacement for mark '%s': %v", frag.rule.mark, err)
+				return fmt.Errorf("unable to write value replacement for mark '%s': %w", frag.rule.mark, err)
 			}
 		case ModeReplace:
 			// ModeReplace writes new fragment over old mark/value pair:
 			//  {
 			//    <FRAGMENT>
 			//  }
-			b.Write(data[pos:frag.markPos])
+			sources.write(b, data, pos, frag.markPos)
 			pos = frag.markPos
-			count, err := frag.writeForReplaceMode(b) // writes <FRAGMENT>
+			count, err := frag.writeForReplaceMode(b, f) // writes <FRAGMENT>
 			if err != nil {
-				return fmt.Errorf("unable to write key-value replacement for mark '%s': %v", frag.rule.mark, err)
+				return fmt.Errorf("unable to write key-value replacement for mark '%s': %w", frag.rule.mark, err)
 			}
 			if count == 0 { // keep old data
-				b.Write(data[pos:frag.endPos])
+				sources.write(b, data, pos, frag.endPos)
 			}
 			pos = frag.endPos
+		case ModeWrap:
+			// ModeWrap wraps value into object with fragment members:
+			//  {
+			//    "mark": {"<preparedKey>": value, <FRAGMENT>}
+			//  }
+			valuePos := skipSpaces(data, frag.argsPos)
+			sources.write(b, data, pos, valuePos)
+			pos = frag.endPos
+			if err := frag.writeForWrapMode(b, data, valuePos, f, sources); err != nil {
+				return fmt.Errorf("unable to write wrap for mark '%s': %w", frag.rule.mark, err)
+			}
+		case ModeUnwrap:
+			// ModeUnwrap writes members of object value over mark/value pair:
+			//  {
+			//    <MEMBERS>
+			//  }
+			if isEmptyObject(bytes.TrimSpace(data[frag.argsPos:frag.endPos])) {
+				pos = deleteMember(b, data, pos, frag, f, sources)
+				break
+			}
+			sources.write(b, data, pos, frag.markPos)
+			pos = frag.endPos
+			frag.writeForUnwrapMode(b, data, f, sources)
 		case ModeInsert:
 			// ModeInsert appends fragment after value as below:
 			//  {
 			//    "<preparedKey>": "value",
 			//    <FRAGMENT>
 			//  }
-			b.Write(data[pos:frag.markPos])
+			sources.write(b, data, pos, frag.markPos)
 			pos = frag.endPos
-			b.WriteString(frag.rule.preparedKey + `:`) // writes `"<preparedKey>":`
-			b.Write(data[frag.argsPos:frag.endPos])    // writes `value`
-			err := frag.writeForInsertMode(b)          // writes `,<FRAGMENT>`
+			b.WriteString(frag.preparedKey() + `:`)           // writes `"<preparedKey>":`
+			sources.write(b, data, frag.argsPos, frag.endPos) // writes `value`
+			err := frag.writeForInsertMode(b, f)              // writes `,<FRAGMENT>`
 			if err != nil {
-				return fmt.Errorf("unable to write insert for mark '%s': %v", frag.rule.mark, err)
+				return fmt.Errorf("unable to write insert for mark '%s': %w", frag.rule.mark, err)
 			}
-		case ModeDelete:
-			if frag.commaPos > 0 { // leading comma exists
-				b.Write(data[pos:frag.commaPos])
-				pos = frag.endPos
-			} else { // no leading comma exists
-				b.Write(data[pos:frag.markPos])
-				pos = frag.endPos
-				if commaPos, found := findCommaPos(data[frag.endPos:]); found {
-					pos += commaPos + 1 // skip forward comma
-				}
+		case ModeDelete, ModeFilterElements:
+			pos = deleteMember(b, data, pos, frag, f, sources)
+		case ModeMapElements:
+			// ModeMapElements writes new fragment over array element:
+			//  [
+			//    <FRAGMENT>
+			//  ]
+			sources.write(b, data, pos, frag.argsPos)
+			pos = frag.endPos
+			if err := frag.writeFragment(b, f); err != nil {
+				return fmt.Errorf("unable to write element replacement for mark '%s': %w", frag.rule.mark, err)
+			}
+		case ModeAppendElements:
+			// ModeAppendElements writes fragment elements after the last element of array value:
+			//  [
+			//    element,
+			//    <FRAGMENT>
+			//  ]
+			var err error
+			if pos, err = frag.writeForAppendMode(b, data, pos, f, sources); err != nil {
+				return fmt.Errorf("unable to write elements append for mark '%s': %w", frag.rule.mark, err)
 			}
 		}
 	}
-	_, err := b.Write(data[pos:]) // write tail
-	return err
+	sources.write(b, data, pos, len(data)) // write tail
+	return nil
+}
+
+// deleteMember writes data from pos up to the mark with its comma and returns position after the mark's value
+func deleteMember(b *bytes.Buffer, data []byte, pos int, frag *fragEntry, f fragmentFormat, sources *sourceMapper) int {
+	if frag.commaPos > 0 && frag.commaPos >= pos { // leading comma exists and isn't skipped with previous member
+		sources.write(b, data, pos, frag.commaPos)
+		return frag.endPos
+	}
+	// no leading comma exists
+	sources.write(b, data, pos, frag.markPos)
+	pos = frag.endPos
+	if commaPos, found := findCommaPos(data[frag.endPos:]); found {
+		pos += commaPos + 1 // skip forward comma
+		if f.indent != "" {
+			pos = skipSpaces(data, pos) // the next member takes the mark's line
+		}
+	}
+	return pos
 }
 
 var (
 	nullLiteral  = []byte("null")
 	trueLiteral  = []byte("true")
 	falseLiteral = []byte("false")
-	asciiSpace   = [256]uint8{'\t': 1, '\n': 1, '\v': 1, '\f': 1, '\r': 1, ' ': 1}
+	asciiSpace   = [256]uint8{'\t': 1, '\n': 1, '\r': 1, ' ': 1} // whitespaces of json, see RFC 8259
 )
 
+// validateJSON panics if data isn't single json value surrounded by whitespaces
+func validateJSON(data []byte) {
+	if i := skipSpaces(data, findJSONFragmentEnd(data)); i < len(data) {
+		panic("invalid json: unexpected " + strconv.Quote(string(data[i])) + " after root value")
+	}
+}
+
 // findJSONFragmentEnd based on https://www.json.org/json-en.html
 func findJSONFragmentEnd(data []byte) int {
-	for i := 0; i < len(data); i++ {
-		c := data[i]
-		if asciiSpace[c] == 1 {
-			continue
-		}
-		if c == '"' {
-			return i + findJSONStringEnd(data[i:]) + 1
-		}
-		if c == '[' || c == '{' {
-			return i + findJSONValueEnd(data[i:]) + 1
-		}
-		if c == '-' || ('0' <= c && c <= '9') {
-			return i + findJSONNumberEnd(data[i:])
-		}
-		if c == 'n' && bytes.Equal(data[i:i+len(nullLiteral)], nullLiteral) {
-			return i + len(nullLiteral)
-		}
-		if c == 't' && bytes.Equal(data[i:i+len(trueLiteral)], trueLiteral) {
-			return i + len(trueLiteral)
-		}
-		if c == 'f' && bytes.Equal(data[i:i+len(falseLiteral)], falseLiteral) {
-			return i + len(falseLiteral)
-		}
-		break
+	i := skipSpaces(data, 0)
+	if i < len(data) && (data[i] == '[' || data[i] == '{') {
+		i += findJSONValueEnd(data[i:]) + 1
+	} else {
+		i += findJSONScalarEnd(data[i:])
+	}
+	// value is followed by separator of its container, i.e. `01` isn't read as `0`
+	if next := skipSpaces(data, i); next < len(data) && data[next] != ',' && data[next] != '}' && data[next] != ']' {
+		panic("invalid json: unexpected " + strconv.Quote(string(data[next])) + " after value")
+	}
+	return i
+}
+
+// findJSONScalarEnd returns length of leading json string, number or literal of data bytes
+func findJSONScalarEnd(data []byte) int {
+	if len(data) == 0 {
+		panic("invalid json: unexpected end of data")
+	}
+	switch c := data[0]; {
+	case c == '"':
+		return findJSONStringEnd(data) + 1
+	case c == '-' || ('0' <= c && c <= '9'):
+		return findJSONNumberEnd(data)
+	case c == 'n' && bytes.HasPrefix(data, nullLiteral):
+		return len(nullLiteral)
+	case c == 't' && bytes.HasPrefix(data, trueLiteral):
+		return len(trueLiteral)
+	case c == 'f' && bytes.HasPrefix(data, falseLiteral):
+		return len(falseLiteral)
 	}
 	panic("invalid json:\n" + string(data))
 }
@@ -528,55 +1212,146 @@ func findJSONFragmentEnd(data []byte) int {
 // For example, []byte(`"value", ...`) returns len of `"value"` (7)
 func findJSONStringEnd(data []byte) int {
 	for i := 1; i < len(data); i++ {
-		switch data[i] {
-		case '\\':
-			i++ // skip next char
-		case '"':
+		switch c := data[i]; {
+		case c == '"':
 			return i
+		case c < 0x20:
+			panic("invalid json: control character in string")
+		case c == '\\':
+			i++ // skip escaped char
+			if i == len(data) {
+				break
+			}
+			switch data[i] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+			case 'u':
+				if i+4 >= len(data) || !isHex(data[i+1]) || !isHex(data[i+2]) || !isHex(data[i+3]) || !isHex(data[i+4]) {
+					panic("invalid json: invalid unicode escape in string")
+				}
+				i += 4
+			default:
+				panic("invalid json: invalid escape in string")
+			}
 		}
 	}
 	panic("invalid json")
 }
 
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
 // findJSONNumberEnd returns length of leading json number of data bytes.
 //
-// Expected format is [+-0-9eE\.]+.*
+// Expected format is -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?.*
 // For example, []byte(`12.34, ...`) returns len of `12.34` (5)
 func findJSONNumberEnd(data []byte) int {
-	for i := 1; i < len(data); i++ {
-		switch data[i] {
-		case '+', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'e', 'E', '.':
-		default:
-			return i
+	i := 0
+	if i < len(data) && data[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(data) && data[i] == '0':
+		i++
+	case i < len(data) && '1' <= data[i] && data[i] <= '9':
+		i = skipDigits(data, i+1)
+	default:
+		panic("invalid json: invalid number")
+	}
+	if i < len(data) && data[i] == '.' {
+		if i = skipDigits(data, i+1); data[i-1] == '.' {
+			panic("invalid json: digit expected after decimal point")
 		}
 	}
-	panic("invalid json")
+	if i < len(data) && (data[i] == 'e' || data[i] == 'E') {
+		i++
+		if i < len(data) && (data[i] == '+' || data[i] == '-') {
+			i++
+		}
+		start := i
+		if i = skipDigits(data, i); i == start {
+			panic("invalid json: digit expected in exponent")
+		}
+	}
+	return i
+}
+
+// skipDigits returns position of the first non-digit char of data starting at i
+func skipDigits(data []byte, i int) int {
+	for i < len(data) && '0' <= data[i] && data[i] <= '9' {
+		i++
+	}
+	return i
 }
 
+// states of findJSONValueEnd
+const (
+	scanValue  = iota // value is expected
+	scanMember        // object key is expected
+	scanNext          // comma or closing bracket is expected
+)
+
 // findJSONValueEnd returns length of leading json array/object of data bytes.
 //
 // It expects first char is '{' or '[' and returns correspond ending literal position. For example:
 // []byte(`[1,2,3], ...`) returns len of `[1,2,3]` (7)
 // []byte(`{}, ...`) returns len of `{}` (2)
+//
+// The value is validated, nested values are scanned without recursion.
 func findJSONValueEnd(data []byte) int {
-	var end byte
-	switch data[0] {
-	case '{':
-		end = '}'
-	case '[':
-		end = ']'
-	}
-	for c := 1; c < len(data); c++ {
-		switch data[c] {
-		case '"':
-			c += findJSONStringEnd(data[c:])
-		case '{', '[':
-			c += findJSONValueEnd(data[c:])
-		case end:
-			return c
+	var closersBuf [32]byte
+	closers := closersBuf[:0] // closing brackets of open containers
+	state := scanValue
+	for i := 0; ; {
+		if i = skipSpaces(data, i); i == len(data) {
+			panic("invalid json: unexpected end of data")
+		}
+		switch state {
+		case scanMember:
+			if data[i] != '"' {
+				panic("invalid json: object key expected")
+			}
+			i += findJSONStringEnd(data[i:]) + 1
+			if i = skipSpaces(data, i); i == len(data) || data[i] != ':' {
+				panic("invalid json: colon expected after object key")
+			}
+			i++
+			state = scanValue
+		case scanValue:
+			switch data[i] {
+			case '{':
+				closers = append(closers, '}')
+				state = scanMember
+			case '[':
+				closers = append(closers, ']')
+				state = scanValue
+			default:
+				i += findJSONScalarEnd(data[i:])
+				state = scanNext
+				continue
+			}
+			if i = skipSpaces(data, i+1); i < len(data) && data[i] == closers[len(closers)-1] {
+				state = scanNext // empty container
+			}
+		case scanNext:
+			closer := closers[len(closers)-1]
+			switch data[i] {
+			case ',':
+				i++
+				state = scanValue
+				if closer == '}' {
+					state = scanMember
+				}
+			case closer:
+				if closers = closers[:len(closers)-1]; len(closers) == 0 {
+					return i
+				}
+				i++
+			default:
+				panic("invalid json: comma or closing bracket expected")
+			}
 		}
 	}
-	panic("invalid json: " + string(data))
 }
 
 // findCommaPos returns first comma occurrence in data, skips only whitespaces
@@ -596,6 +1371,15 @@ func findCommaPos(data []byte) (int, bool) {
 	panic("invalid json")
 }
 
+// rawValueGenerator returns raw json values of marks
+func rawValueGenerator(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+	entities := make([]interface{}, 0, iterator.Count())
+	for iterator.Next() {
+		entities = append(entities, json.RawMessage(iterator.Bytes()))
+	}
+	return entities, nil
+}
+
 func EmptyFragmentsGenerator(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
 	entities := make([]interface{}, iterator.Count())
 	for i := 0; iterator.Next(); i++ {
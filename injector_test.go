@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -72,6 +74,15 @@ func TestProcess(t *testing.T) {
 			input: `{"mark": "value"}`,
 			want:  `{}`,
 		},
+		{
+			name: "delete adjacent",
+			rules: []*Rule{
+				NewDeleteRule("mark"),
+				NewDeleteRule("next"),
+			},
+			input: `{"mark": "value", "next": 1}`,
+			want:  `{}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -204,6 +215,189 @@ func prefixedObject(t *testing.T, val string) string {
 	return prefix + val[1:]
 }
 
+func TestProcess_wrap(t *testing.T) {
+	currency := Constant(map[string]string{"currency": "EUR"})
+	tests := []struct {
+		name    string
+		rule    *Rule
+		params  ProcessParams
+		repeats int // 1 if zero
+		input   string
+		want    string
+	}{
+		{
+			name:  "wrap",
+			rule:  NewWrapRule("price", "amount", currency),
+			input: `{"price": 10, "id": 1}`,
+			want:  `{"price": {"amount":10,"currency":"EUR"}, "id": 1}`,
+		},
+		{
+			name:  "wrap without members",
+			rule:  NewWrapRule("price", "price", EmptyFragmentsGenerator),
+			input: `{"price": [1, 2]}`,
+			want:  `{"price": {"price":[1, 2]}}`,
+		},
+		{
+			name:    "wrap once per pass",
+			rule:    NewWrapRule("price", "amount", currency),
+			repeats: 2,
+			input:   `{"price": 10, "items": [{"price": {"price": 2}}]}`,
+			want:    `{"price": {"amount":10,"currency":"EUR"}, "items": [{"price": {"amount":{"price": 2},"currency":"EUR"}}]}`,
+		},
+		{
+			name:   "wrap indented",
+			rule:   NewWrapRule("price", "amount", currency),
+			params: ProcessParams{PreserveIndent: true},
+			input:  "{\n  \"price\": [\n    10\n  ],\n  \"id\": 1\n}",
+			want:   "{\n  \"price\": {\n    \"amount\": [\n      10\n    ],\n    \"currency\": \"EUR\"\n  },\n  \"id\": 1\n}",
+		},
+		{
+			name:  "unwrap",
+			rule:  NewUnwrapRule("meta"),
+			input: `{"id": 1, "meta": {"a": 1, "b": {"c": 2}}, "name": "a"}`,
+			want:  `{"id": 1, "a": 1, "b": {"c": 2}, "name": "a"}`,
+		},
+		{
+			name:  "unwrap as is",
+			rule:  NewUnwrapRule("meta"),
+			input: `{"id": 1, "meta": { "html": "<b>&",  "n": 1.50 }}`,
+			want:  `{"id": 1, "html": "<b>&",  "n": 1.50}`,
+		},
+		{
+			name:  "unwrap empty object",
+			rule:  NewUnwrapRule("meta"),
+			input: `[{"meta": {}, "id": 1}, {"id": 2, "meta": { }}]`,
+			want:  `[{ "id": 1}, {"id": 2}]`,
+		},
+		{
+			name:  "unwrap non-object",
+			rule:  NewUnwrapRule("meta"),
+			input: `{"meta": [{"meta": {"a": 1}}], "b": null, "meta": null}`,
+			want:  `{"meta": [{"a": 1}], "b": null, "meta": null}`,
+		},
+		{
+			name:   "unwrap indented",
+			rule:   NewUnwrapRule("meta"),
+			params: ProcessParams{PreserveIndent: true},
+			input:  "{\n  \"id\": 1,\n  \"meta\": {\n    \"a\": [\n      1\n    ]\n  }\n}",
+			want:   "{\n  \"id\": 1,\n  \"a\": [\n    1\n  ]\n}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Passes = []Pass{{RuleSet: NewRuleSet(tt.rule), Repeats: max(tt.repeats, 1)}}
+			output, err := Process(context.Background(), []byte(tt.input), tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestProcess_keyValue(t *testing.T) {
+	localize := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		var result []interface{}
+		for iterator.Next() {
+			var title struct {
+				Lang, Text string
+			}
+			if err := iterator.BindParams(&title); err != nil {
+				return nil, err
+			}
+			result = append(result, KeyValue{Key: "title_" + title.Lang, Value: title.Text})
+		}
+		return result, nil
+	}
+	meta := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		var result []interface{}
+		for i := 0; iterator.Next(); i++ {
+			if i == 0 {
+				result = append(result, &KeyValue{Key: `"quoted"`, Value: map[string]int{"n": i}})
+			} else {
+				result = append(result, map[string]int{"n": i})
+			}
+		}
+		return result, nil
+	}
+
+	tests := []struct {
+		name  string
+		rule  *Rule
+		input string
+		want  string
+	}{
+		{
+			name:  "replace value",
+			rule:  NewReplaceValueRule("title", "title", localize),
+			input: `[{"title": {"Lang": "en", "Text": "Cat"}}, {"title": {"Lang": "de", "Text": "Katze"}}]`,
+			want:  `[{"title_en":"Cat"}, {"title_de":"Katze"}]`,
+		},
+		{
+			name:  "insert",
+			rule:  NewInsertRule("id", "key", meta),
+			input: `[{"id": 1}, {"id": 2}]`,
+			want:  `[{"\"quoted\"": 1,"n":0}, {"key": 2,"n":1}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(tt.rule), Repeats: 1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestProcess_escapedKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []*Rule
+		input string
+		want  string
+	}{
+		{
+			name:  "escaped mark",
+			rules: []*Rule{NewReplaceValueRule("pet_id", "id", Constant(1))},
+			input: `[{"pet\u005fid": 0}, {"pet_id": 0}, {"pet\\_id": 0}]`,
+			want:  `[{"id":1}, {"id":1}, {"pet\\_id": 0}]`,
+		},
+		{
+			name:  "mark to be escaped",
+			rules: []*Rule{NewDeleteRule(`a"b`)},
+			input: `{"a\"b": 1, "x\ny": 2, "a\u0022b": 3}`,
+			want:  `{ "x\ny": 2}`,
+		},
+		{
+			name:  "key escaping",
+			rules: []*Rule{NewReplaceValueRule("id", "\"\\\n\x01\xff", Constant(1))},
+			input: `{"id": 0}`,
+			want:  `{"\"\\\n\u0001\ufffd":1}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(tt.rules...), Repeats: 1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
+
 func Test_findJSONFragmentEnd(t *testing.T) {
 	tests := []struct {
 		name string
@@ -297,6 +491,219 @@ func Test_findJSONFragmentEnd(t *testing.T) {
 	}
 }
 
+func Test_findJSONFragmentEnd_invalid(t *testing.T) {
+	tests := []string{
+		` [1-2e.e]`,
+		` [01]`,
+		` 01`,
+		` 1-2`,
+		` 1.`,
+		` 1e`,
+		` -`,
+		` nul`,
+		` tru`,
+		` "\x"`,
+		` "\u12g4"`,
+		` "\u12"`,
+		" \"\n\"",
+		` "value`,
+		` [1, 2`,
+		` [1,]`,
+		` [,]`,
+		` [1 2]`,
+		` [}`,
+		` {"key" 1}`,
+		` {"key": 1,}`,
+		` {1: 1}`,
+		` {"key": [}]}`,
+		" [1,\f2]",
+		" {\"key\":\v1}",
+	}
+	for _, data := range tests {
+		t.Run(data, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("findJSONFragmentEnd doesn't panic on invalid json")
+				}
+			}()
+			findJSONFragmentEnd([]byte(data + ", "))
+		})
+	}
+}
+
+func TestProcess_invalidJSON(t *testing.T) {
+	passes := []Pass{{RuleSet: NewRuleSet(NewDeleteRule("mark")), Repeats: 1}}
+	for _, input := range []string{`{"mark": [1, 2}`, `{"mark": 01}`, `{"mark": "value`} {
+		t.Run(input, func(t *testing.T) {
+			_, err := Process(context.Background(), []byte(input), ProcessParams{Passes: passes})
+			if !errors.Is(err, ErrInvalidJSON) {
+				t.Errorf("ErrInvalidJSON expected, got %v", err)
+			}
+		})
+	}
+
+	t.Run("validate input", func(t *testing.T) {
+		for _, input := range []string{`{"mark": 1} xx`, `{"mark": 1}]`, `[1 2, {"mark": 1}]`, `{"a": 1} {"b": 2}`, `[1`} {
+			_, err := Process(context.Background(), []byte(input), ProcessParams{Passes: passes})
+			if err != nil {
+				t.Errorf("input outside of marks is validated by default: %s: %v", input, err)
+			}
+			_, err = Process(context.Background(), []byte(input), ProcessParams{Passes: passes, ValidateInput: true})
+			if !errors.Is(err, ErrInvalidJSON) {
+				t.Errorf("ErrInvalidJSON expected: %s: %v", input, err)
+			}
+		}
+	})
+
+	t.Run("find marks", func(t *testing.T) {
+		_, err := passes[0].RuleSet.FindMarks([]byte(`{"mark": [1, 2}`))
+		if !errors.Is(err, ErrInvalidJSON) {
+			t.Errorf("ErrInvalidJSON expected, got %v", err)
+		}
+	})
+
+	t.Run("depth limit", func(t *testing.T) {
+		// input is scanned by the limit before passes
+		pool := &countingPool{BufferPool: NewBufferPool()}
+		p := NewProcessor(WithBufferPool(pool))
+		_, err := p.Process(context.Background(), []byte(`{"a": "unterminated`), ProcessParams{Passes: passes, Limits: Limits{MaxDepth: 8}})
+		if !errors.Is(err, ErrInvalidJSON) {
+			t.Errorf("ErrInvalidJSON expected, got %v", err)
+		}
+		if pool.gets != pool.puts {
+			t.Errorf("%d buffers are taken from the pool, %d are returned", pool.gets, pool.puts)
+		}
+	})
+}
+
+// cancelHooks cancels context when pass starts
+type cancelHooks struct {
+	NopHooks
+	cancel context.CancelFunc
+}
+
+func (h cancelHooks) OnPassStart(int, int) {
+	h.cancel()
+}
+
+func TestProcess_context(t *testing.T) {
+	manyMarks := "[" + strings.Repeat(`{"mark": 1},`, 1000) + `{"mark": 1}]`
+	tests := []struct {
+		name  string
+		input string
+		// cancel is called before Process, by hooks or by generator
+		cancelBy string
+		want     string
+	}{
+		{
+			name:     "before process",
+			input:    `{"mark": 1}`,
+			cancelBy: "caller",
+			want:     "pass 1, repeat 1 interrupted: context canceled",
+		},
+		{
+			name:     "scan",
+			input:    manyMarks,
+			cancelBy: "hooks",
+			want:     "pass 1, repeat 1 interrupted: context canceled",
+		},
+		{
+			name:     "write",
+			input:    manyMarks,
+			cancelBy: "generator",
+			want:     "pass 1, repeat 1 interrupted: context canceled",
+		},
+		{
+			name:     "between repeats",
+			input:    `{"mark": 1}`,
+			cancelBy: "generator",
+			want:     "pass 1, repeat 2 interrupted: context canceled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			calls := 0
+			generator := func(ctx context.Context, iterator FragmentIterator, p interface{}) ([]interface{}, error) {
+				calls++
+				if tt.cancelBy == "generator" {
+					cancel()
+				}
+				return EmptyFragmentsGenerator(ctx, iterator, p)
+			}
+			params := ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("mark", "mark", generator)), Repeats: 2}},
+			}
+			switch tt.cancelBy {
+			case "caller":
+				cancel()
+			case "hooks":
+				params.Hooks = cancelHooks{cancel: cancel}
+			}
+
+			_, err := Process(ctx, []byte(tt.input), params)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("context.Canceled expected, got %v", err)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, err)
+			}
+			if tt.cancelBy != "generator" && calls != 0 {
+				t.Errorf("generator is called %d times after cancel", calls)
+			}
+		})
+	}
+}
+
+// findJSONValueEndRecursive is previous recursive version of findJSONValueEnd kept for benchmark
+func findJSONValueEndRecursive(data []byte) int {
+	var end byte
+	switch data[0] {
+	case '{':
+		end = '}'
+	case '[':
+		end = ']'
+	}
+	for c := 1; c < len(data); c++ {
+		switch data[c] {
+		case '"':
+			for c++; data[c] != '"'; c++ {
+				if data[c] == '\\' {
+					c++
+				}
+			}
+		case '{', '[':
+			c += findJSONValueEndRecursive(data[c:])
+		case end:
+			return c
+		}
+	}
+	panic("invalid json: " + string(data))
+}
+
+func Benchmark_findJSONValueEnd(b *testing.B) {
+	flat := []byte(`[` + strings.Repeat(`{"pet_id": 123456789, "name": "KittyCat", "weight": -1.5e3, "cat": true},`, 100) + `null]`)
+	nested := []byte(strings.Repeat(`{"children": [`, 500) + strings.Repeat(`]}`, 500))
+	for _, data := range []struct {
+		name string
+		data []byte
+	}{{"flat", flat}, {"nested", nested}} {
+		b.Run(data.name+"/iterative", func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				findJSONValueEnd(data.data)
+			}
+		})
+		b.Run(data.name+"/recursive", func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				findJSONValueEndRecursive(data.data)
+			}
+		})
+	}
+}
+
 func BenchmarkProcess(b *testing.B) {
 	input := []byte(`{
     "pet_id": 123456789,
@@ -439,3 +846,37 @@ func assertJSONEqual(t *testing.T, expected, actual string) {
 		t.Errorf("Not equal:\n  expected: %s\n  actual: %s", expectedJSON, actualJSON)
 	}
 }
+
+func TestProcess_concurrent(t *testing.T) {
+	// passes are shared by requests of httpj middleware
+	passes := []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("mark", "key", Constant(2))), Repeats: 1}}
+	inputs := []string{`{"mark": 1}`, `{"m\u0061rk": 1}`} // regexps of keys with and without escapes
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(input string) {
+			defer wg.Done()
+			output, err := Process(context.Background(), []byte(input), ProcessParams{Passes: passes})
+			if err != nil || string(output) != `{"key":2}` {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s, %v", `{"key":2}`, output, err)
+			}
+		}(inputs[i%len(inputs)])
+	}
+	wg.Wait()
+}
+
+func TestRuleSet_AddRule(t *testing.T) {
+	set := NewRuleSet(NewDeleteRule("a"))
+	params := ProcessParams{Passes: []Pass{{RuleSet: set, Repeats: 1}}}
+	if _, err := Process(context.Background(), []byte(`{"a": 1, "b": 2}`), params); err != nil {
+		t.Fatal(err)
+	}
+	set.AddRule(NewDeleteRule("b")) // regexps compiled by Process include the added mark
+	output, err := Process(context.Background(), []byte(`{"a": 1, "b": 2, "c": 3}`), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != `{  "c": 3}` {
+		t.Errorf("Not equal:\n  expected: %s\n  actual: %s", `{  "c": 3}`, output)
+	}
+}
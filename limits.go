@@ -0,0 +1,75 @@
+package jsonj
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Limits restricts resources used by Process, zero fields aren't checked
+type Limits struct {
+	MaxInputBytes  int // size of input
+	MaxDepth       int // nesting depth of input objects and arrays, checked without recursion
+	MaxMarks       int // number of marks matched by a pass repeat
+	MaxOutputBytes int // size of output, checked while fragments are written and after every output stage
+}
+
+// LimitExceededError is returned by Process if one of Limits is exceeded
+type LimitExceededError struct {
+	Limit string // name of Limits field
+	Max   int
+	Value int // exceeding value, Max+1 for MaxDepth as scanning stops on exceeding
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("limit %s exceeded: %d > %d", e.Limit, e.Value, e.Max)
+}
+
+// checkInput checks input size and depth
+func (l Limits) checkInput(data []byte) error {
+	if l.MaxInputBytes > 0 && len(data) > l.MaxInputBytes {
+		return &LimitExceededError{Limit: "MaxInputBytes", Max: l.MaxInputBytes, Value: len(data)}
+	}
+	if l.MaxDepth > 0 && exceedsDepth(data, l.MaxDepth) {
+		return &LimitExceededError{Limit: "MaxDepth", Max: l.MaxDepth, Value: l.MaxDepth + 1}
+	}
+	return nil
+}
+
+// checkOutput checks output size
+func (l Limits) checkOutput(size int) error {
+	if l.MaxOutputBytes > 0 && size > l.MaxOutputBytes {
+		return &LimitExceededError{Limit: "MaxOutputBytes", Max: l.MaxOutputBytes, Value: size}
+	}
+	return nil
+}
+
+// limitedWriter writes to buffer while its size doesn't exceed max, see Limits.MaxOutputBytes
+type limitedWriter struct {
+	b   *bytes.Buffer
+	max int
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	if size := w.b.Len() + len(p); size > w.max {
+		return 0, &LimitExceededError{Limit: "MaxOutputBytes", Max: w.max, Value: size}
+	}
+	return w.b.Write(p)
+}
+
+// exceedsDepth reports whether objects and arrays of json data are nested deeper than max
+func exceedsDepth(data []byte, max int) bool {
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			i += findJSONStringEnd(data[i:])
+		case '{', '[':
+			if depth++; depth > max {
+				return true
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package jsonj
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProcess_limits(t *testing.T) {
+	passes := []Pass{{
+		RuleSet: NewRuleSet(NewInsertRule("id", "uuid", Constant(map[string]string{"url": "https://zoo.com"}))),
+		Repeats: 1,
+	}}
+	const input = `[{"id": 1}, {"id": 2}, [[{"id": 3}]]]`
+
+	tests := []struct {
+		name   string
+		limits Limits
+		input  string
+		want   *LimitExceededError
+	}{
+		{
+			name:   "within limits",
+			limits: Limits{MaxInputBytes: len(input), MaxDepth: 4, MaxMarks: 3, MaxOutputBytes: 200},
+			input:  input,
+		},
+		{
+			name:   "input bytes",
+			limits: Limits{MaxInputBytes: 10},
+			input:  input,
+			want:   &LimitExceededError{Limit: "MaxInputBytes", Max: 10, Value: len(input)},
+		},
+		{
+			name:   "depth",
+			limits: Limits{MaxDepth: 3},
+			input:  input,
+			want:   &LimitExceededError{Limit: "MaxDepth", Max: 3, Value: 4},
+		},
+		{
+			name:   "depth of malicious input",
+			limits: Limits{MaxDepth: 1000},
+			input:  strings.Repeat("[", 1e6) + strings.Repeat("]", 1e6),
+			want:   &LimitExceededError{Limit: "MaxDepth", Max: 1000, Value: 1001},
+		},
+		{
+			name:   "brackets inside strings",
+			limits: Limits{MaxDepth: 1},
+			input:  `{"id": "[[{{\"[["}`,
+		},
+		{
+			name:   "marks",
+			limits: Limits{MaxMarks: 2},
+			input:  input,
+			want:   &LimitExceededError{Limit: "MaxMarks", Max: 2, Value: 3},
+		},
+		{
+			name:   "output bytes",
+			limits: Limits{MaxOutputBytes: 50},
+			input:  input,
+			want:   &LimitExceededError{Limit: "MaxOutputBytes", Max: 50, Value: 74},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(context.Background(), []byte(tt.input), ProcessParams{Passes: passes, Limits: tt.limits})
+			if tt.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var limitErr *LimitExceededError
+			if !errors.As(err, &limitErr) || *limitErr != *tt.want {
+				t.Errorf("Not equal:\n  expected: %v\n  actual: %v", tt.want, err)
+			}
+		})
+	}
+	t.Run("huge fragment", func(t *testing.T) {
+		// buffers larger than the limit aren't returned to the pool
+		pool := &countingPool{BufferPool: NewBufferPool()}
+		p := NewProcessor(WithBufferPool(pool), WithMaxBufferSize(1024))
+		_, err := p.Process(context.Background(), []byte(`{"id": 1}`), ProcessParams{
+			Passes: []Pass{{
+				RuleSet: NewRuleSet(NewReplaceValueRule("id", "uuid", Constant(strings.Repeat("x", 1<<20)))),
+				Repeats: 1,
+			}},
+			Limits: Limits{MaxOutputBytes: 1000},
+		})
+		want := LimitExceededError{Limit: "MaxOutputBytes", Max: 1000, Value: len(`{"uuid":""`+"\n") + 1<<20}
+		var limitErr *LimitExceededError
+		if !errors.As(err, &limitErr) || *limitErr != want {
+			t.Errorf("Not equal:\n  expected: %v\n  actual: %v", &want, err)
+		}
+		if pool.gets != pool.puts {
+			t.Errorf("%d buffers are taken from the pool, %d are returned", pool.gets, pool.puts)
+		}
+	})
+	t.Run("without passes", func(t *testing.T) {
+		for _, tt := range []struct {
+			limits Limits
+			input  string
+			want   LimitExceededError
+		}{
+			{limits: Limits{MaxInputBytes: 3}, input: `{"a": [[[[1]]]]}`, want: LimitExceededError{"MaxInputBytes", 3, 16}},
+			{limits: Limits{MaxDepth: 2}, input: `{"a": [[[[1]]]]}`, want: LimitExceededError{"MaxDepth", 2, 3}},
+			{limits: Limits{MaxInputBytes: 1}, input: `{}`, want: LimitExceededError{"MaxInputBytes", 1, 2}},
+			{limits: Limits{MaxOutputBytes: 1}, input: `[]`, want: LimitExceededError{"MaxOutputBytes", 1, 2}},
+		} {
+			_, err := Process(context.Background(), []byte(tt.input), ProcessParams{Limits: tt.limits})
+			var limitErr *LimitExceededError
+			if !errors.As(err, &limitErr) || *limitErr != tt.want {
+				t.Errorf("Not equal:\n  expected: %v\n  actual: %v", &tt.want, err)
+			}
+		}
+	})
+}
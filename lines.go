@@ -0,0 +1,203 @@
+package jsonj
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MalformedLinesPolicy determines how ProcessLines handles lines of invalid json
+type MalformedLinesPolicy int
+
+const (
+	MalformedLinesError       MalformedLinesPolicy = iota // ProcessLines fails with *MalformedLineError
+	MalformedLinesSkip                                    // line isn't written to the output
+	MalformedLinesPassThrough                             // line is written to the output as is
+)
+
+func (p MalformedLinesPolicy) String() string {
+	switch p {
+	case MalformedLinesError:
+		return "Error"
+	case MalformedLinesSkip:
+		return "Skip"
+	case MalformedLinesPassThrough:
+		return "PassThrough"
+	default:
+		panic("unknown malformed lines policy value")
+	}
+}
+
+// MalformedLineError describes line of invalid json, it wraps ErrInvalidJSON
+type MalformedLineError struct {
+	Line int // starting from 1
+}
+
+func (e *MalformedLineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, ErrInvalidJSON)
+}
+
+func (e *MalformedLineError) Unwrap() error {
+	return ErrInvalidJSON
+}
+
+// DefaultLinesWindow is number of lines processed in one batch by default
+const DefaultLinesWindow = 1000
+
+// LinesParams describes parameters of ProcessLines
+type LinesParams struct {
+	ProcessParams // applied to every window of lines, output is written compact, see ProcessLines
+
+	Window    int                  // lines processed in one batch, DefaultLinesWindow if not positive
+	Malformed MalformedLinesPolicy // lines of invalid json fail processing by default
+}
+
+// ProcessLines processes newline-delimited json (JSON Lines) read from r and writes it to w,
+// see Processor.ProcessLines
+func ProcessLines(ctx context.Context, r io.Reader, w io.Writer, params LinesParams) error {
+	return defaultProcessor.ProcessLines(ctx, r, w, params)
+}
+
+// ProcessLines processes newline-delimited json (JSON Lines) read from r and writes it to w.
+//
+// Every line is a document. Lines are processed by windows, marks of all lines of a window are generated
+// in one batch, i.e. one generator call resolves ids of 1000 lines. Order of lines is kept,
+// blank lines are skipped.
+//
+// Limits are checked for every line, except MaxMarks limiting marks of a window to MaxMarks per line of the window.
+// Trace, Patch and SourceMap can't be split by lines and aren't supported.
+func (p *Processor) ProcessLines(ctx context.Context, r io.Reader, w io.Writer, params LinesParams) error {
+	switch {
+	case params.Trace != nil:
+		return errors.New("trace of lines isn't supported")
+	case params.Patch != nil:
+		return errors.New("patch of lines isn't supported")
+	case params.SourceMap != nil:
+		return errors.New("source map of lines isn't supported")
+	}
+	params.ProcessParams = p.withDefaults(params.ProcessParams)
+	window := params.Window
+	if window <= 0 {
+		window = DefaultLinesWindow
+	}
+	batch := &linesBatch{}
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("unable to read line %d: %w", n, err)
+		}
+		eof := err != nil
+		line = bytes.TrimRight(line, "\r\n")
+		if document := bytes.TrimSpace(line); len(document) > 0 {
+			switch {
+			case json.Valid(document):
+				if err := params.Limits.checkInput(document); err != nil {
+					return fmt.Errorf("line %d: %w", n, err)
+				}
+				batch.add(n, document, true)
+			case params.Malformed == MalformedLinesError:
+				return &MalformedLineError{Line: n}
+			case params.Malformed == MalformedLinesPassThrough:
+				batch.add(n, line, false)
+			}
+		}
+		if batch.documents == window || (eof && len(batch.lines) > 0) {
+			if err := p.processBatch(ctx, batch, w, &params.ProcessParams); err != nil {
+				return err
+			}
+			batch.reset()
+		}
+		if eof {
+			return nil
+		}
+	}
+}
+
+// linesBatch is window of lines, documents are joined into json array to be processed at once
+type linesBatch struct {
+	lines     []batchLine
+	array     []byte // `[document1,document2,...]`
+	documents int
+	output    []byte // reused memory of processed array
+	written   []byte // reused memory of written lines
+}
+
+// batchLine is line of linesBatch
+type batchLine struct {
+	number   int
+	data     []byte // line passed through, nil for documents
+	document bool
+}
+
+func (b *linesBatch) add(n int, line []byte, document bool) {
+	if !document {
+		b.lines = append(b.lines, batchLine{number: n, data: append([]byte(nil), line...)})
+		return
+	}
+	if b.documents == 0 {
+		b.array = append(b.array[:0], '[')
+	} else {
+		b.array = append(b.array, ',')
+	}
+	b.array = append(b.array, line...)
+	b.documents++
+	b.lines = append(b.lines, batchLine{number: n, document: true})
+}
+
+func (b *linesBatch) reset() {
+	b.lines = b.lines[:0]
+	b.documents = 0
+}
+
+// processBatch writes lines of batch to w, documents are processed as one json array.
+// Limits of params are checked by lines, only marks are limited for the array.
+func (p *Processor) processBatch(ctx context.Context, b *linesBatch, w io.Writer, params *ProcessParams) error {
+	var documents []arrayElement
+	if b.documents > 0 {
+		b.array = append(b.array, ']')
+		arrayParams := *params
+		arrayParams.Limits = Limits{MaxMarks: params.Limits.MaxMarks * b.documents}
+		result, err := p.run(ctx, b.array, arrayParams)
+		if err != nil {
+			return fmt.Errorf("unable to process lines %d-%d: %w", b.lines[0].number, b.lines[len(b.lines)-1].number, err)
+		}
+		if result == nil {
+			b.output = append(b.output[:0], b.array...)
+		} else {
+			b.output = append(b.output[:0], result.Bytes()...)
+			p.freeBuffer(result)
+		}
+		documents = arrayElements(b.output, skipSpaces(b.output, 0))
+	}
+
+	out := bytes.NewBuffer(b.written[:0])
+	for _, line := range b.lines {
+		if !line.document {
+			out.Write(line.data)
+			out.WriteByte('\n')
+			continue
+		}
+		document := b.output[documents[0].valuePos:documents[0].endPos]
+		documents = documents[1:]
+		size := out.Len()
+		if bytes.IndexByte(document, '\n') == -1 {
+			out.Write(document)
+		} else if err := json.Compact(out, document); err != nil { // indented by params
+			return fmt.Errorf("unable to write line %d: %w", line.number, err)
+		}
+		if err := params.Limits.checkOutput(out.Len() - size); err != nil {
+			return fmt.Errorf("line %d: %w", line.number, err)
+		}
+		out.WriteByte('\n')
+	}
+	b.written = out.Bytes()
+	if _, err := w.Write(b.written); err != nil {
+		return fmt.Errorf("unable to write lines: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProcessLines(t *testing.T) {
+	var batches []int // sizes of generator batches
+	generateUUID := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		batches = append(batches, iterator.Count())
+		var result []interface{}
+		for iterator.Next() {
+			var id int
+			if err := iterator.BindParams(&id); err != nil {
+				return nil, err
+			}
+			result = append(result, "uuid-"+strconv.Itoa(id))
+		}
+		return result, nil
+	}
+	passes := []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("pet_id", "pet_uuid", generateUUID)), Repeats: 1}}
+
+	tests := []struct {
+		name        string
+		params      LinesParams
+		input       string
+		want        string
+		wantBatches []int
+	}{
+		{
+			name:   "windows",
+			params: LinesParams{Window: 2},
+			input: `{"pet_id": 1}` + "\n" +
+				`[{"pet_id": 2}, {"pet_id": 3}]` + "\r\n" +
+				`{"name": "KittyCat"}` + "\n" +
+				"\n" +
+				`{"pet_id": 4}`,
+			want: `{"pet_uuid":"uuid-1"}` + "\n" +
+				`[{"pet_uuid":"uuid-2"}, {"pet_uuid":"uuid-3"}]` + "\n" +
+				`{"name": "KittyCat"}` + "\n" +
+				`{"pet_uuid":"uuid-4"}` + "\n",
+			wantBatches: []int{3, 1},
+		},
+		{
+			name:        "skip malformed",
+			params:      LinesParams{Malformed: MalformedLinesSkip},
+			input:       "{\"pet_id\": 1}\n{\"pet_id\": \n{\"pet_id\": 2}\n",
+			want:        "{\"pet_uuid\":\"uuid-1\"}\n{\"pet_uuid\":\"uuid-2\"}\n",
+			wantBatches: []int{2},
+		},
+		{
+			name:        "pass malformed through",
+			params:      LinesParams{Malformed: MalformedLinesPassThrough},
+			input:       "{\"pet_id\": 1}\n{\"pet_id\": \n{\"pet_id\": 2}\n",
+			want:        "{\"pet_uuid\":\"uuid-1\"}\n{\"pet_id\": \n{\"pet_uuid\":\"uuid-2\"}\n",
+			wantBatches: []int{2},
+		},
+		{
+			name:        "indented output",
+			params:      LinesParams{ProcessParams: ProcessParams{Output: OutputIndent}},
+			input:       `{"pet_id": 1, "tags": ["cat"]}`,
+			want:        `{"pet_uuid":"uuid-1","tags":["cat"]}` + "\n",
+			wantBatches: []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches = nil
+			tt.params.Passes = passes
+			var w bytes.Buffer
+			if err := ProcessLines(context.Background(), strings.NewReader(tt.input), &w, tt.params); err != nil {
+				t.Fatal(err)
+			}
+			if w.String() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, w.String())
+			}
+			if !slices.Equal(batches, tt.wantBatches) {
+				t.Errorf("Not equal batches:\n  expected: %v\n  actual: %v", tt.wantBatches, batches)
+			}
+		})
+	}
+}
+
+func TestProcessLines_errors(t *testing.T) {
+	passes := []Pass{{RuleSet: NewRuleSet(NewDeleteRule("id")), Repeats: 1}}
+
+	t.Run("malformed line", func(t *testing.T) {
+		var w bytes.Buffer
+		err := ProcessLines(context.Background(), strings.NewReader("{\"id\": 1}\n{\"id\": \n"), &w, LinesParams{
+			ProcessParams: ProcessParams{Passes: passes},
+		})
+		var lineErr *MalformedLineError
+		if !errors.As(err, &lineErr) || lineErr.Line != 2 || !errors.Is(err, ErrInvalidJSON) {
+			t.Errorf("MalformedLineError of line 2 expected, got %v", err)
+		}
+		if w.Len() != 0 {
+			t.Errorf("lines of unfinished window are written: %s", w.String())
+		}
+	})
+
+	t.Run("limits of lines", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			limits Limits
+			input  string
+			want   string
+		}{
+			{
+				name:   "input",
+				limits: Limits{MaxInputBytes: 20},
+				input:  "{\"id\": 1}\n{\"id\": 2}\n{\"id\": 3, \"abcde\": 4}\n",
+				want:   "line 3: limit MaxInputBytes exceeded: 21 > 20",
+			},
+			{
+				name:   "output",
+				limits: Limits{MaxOutputBytes: 10},
+				input:  "{\"id\": 1, \"a\": 2}\n{\"id\": 3, \"abcde\": 4}\n",
+				want:   "line 2: limit MaxOutputBytes exceeded: 13 > 10",
+			},
+			{
+				name:   "marks per line of window",
+				limits: Limits{MaxMarks: 1},
+				input:  "{\"id\": 1}\n[{\"id\": 2}, {\"id\": 3}]\n{\"id\": 4}\n",
+				want:   "unable to process lines 1-2: unable to do pass 0: limit MaxMarks exceeded: 3 > 2",
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var w bytes.Buffer
+				err := ProcessLines(context.Background(), strings.NewReader(tt.input), &w, LinesParams{
+					ProcessParams: ProcessParams{Passes: passes, Limits: tt.limits},
+					Window:        2,
+				})
+				if err == nil || err.Error() != tt.want {
+					t.Errorf("Not equal:\n  expected: %s\n  actual: %v", tt.want, err)
+				}
+			})
+		}
+	})
+
+	t.Run("unsupported params", func(t *testing.T) {
+		err := ProcessLines(context.Background(), strings.NewReader("{\"id\": 1}\n"), io.Discard, LinesParams{
+			ProcessParams: ProcessParams{Passes: passes, Patch: &Patch{}},
+		})
+		const want = "patch of lines isn't supported"
+		if err == nil || err.Error() != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %v", want, err)
+		}
+	})
+}
@@ -0,0 +1,402 @@
+package jsonj
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Patch is JSON Patch (RFC 6902) describing changes done by Process, see ProcessParams.Patch.
+// Operations of a pass repeat are applied to output of the previous one, so patch is applied by ApplyPatch
+// to input of Process in order of operations.
+type Patch []PatchOperation
+
+// PatchOperation is operation of JSON Patch: add, remove, replace or move
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`           // JSON Pointer (RFC 6901) of the target
+	From  string          `json:"from,omitempty"` // JSON Pointer of the moved value
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// addOperations records operations of fragments rewriting data during a pass repeat.
+//
+// Paths of all operations are valid in data: removed members go first, so they don't remove members added
+// by other fragments, then moved ones before their keys are reused, then added and replaced values
+// in order of data. Removed array elements go last in reverse order, so they don't shift indexes.
+func (p *Patch) addOperations(data []byte, fragments []*fragEntry, escapeHTML bool) error {
+	paths := valuePaths(data)
+	var removals, moves, others, elemRemovals Patch
+	for _, frag := range fragments {
+		valuePos := skipSpaces(data, frag.argsPos)
+		path := paths[valuePos]
+		parent := path[:strings.LastIndexByte(path, '/')+1] // with trailing slash
+		remove := PatchOperation{Op: "remove", Path: path}
+
+		switch frag.rule.mode {
+		case ModeReplaceValue, ModeRedact:
+			value, err := encodePatchFragment(frag, escapeHTML)
+			if err != nil {
+				return err
+			}
+			key := decodeKey([]byte(frag.preparedKey()))
+			if key == frag.rule.mark {
+				others = append(others, PatchOperation{Op: "replace", Path: path, Value: value})
+				break
+			}
+			removals = append(removals, remove)
+			others = append(others, PatchOperation{Op: "add", Path: parent + escapePointerToken(key), Value: value})
+		case ModeReplace:
+			value, err := encodePatchFragment(frag, escapeHTML)
+			if err != nil {
+				return err
+			}
+			members, err := patchMembers(parent, value)
+			if err != nil {
+				return err
+			}
+			if len(members) == 0 {
+				break // mark is kept
+			}
+			removals = append(removals, remove)
+			others = append(others, members...)
+		case ModeUnwrap:
+			members, err := patchMembers(parent, data[valuePos:frag.endPos]) // members are written as is
+			if err != nil {
+				return err
+			}
+			removals = append(removals, remove)
+			others = append(others, members...)
+		case ModeInsert:
+			if key := decodeKey([]byte(frag.preparedKey())); key != frag.rule.mark {
+				moves = append(moves, PatchOperation{Op: "move", From: path, Path: parent + escapePointerToken(key)})
+			}
+			value, err := encodePatchFragment(frag, escapeHTML)
+			if err != nil {
+				return err
+			}
+			members, err := patchMembers(parent, value)
+			if err != nil {
+				return err
+			}
+			others = append(others, members...)
+		case ModeWrap:
+			var b bytes.Buffer
+			if err := frag.writeForWrapMode(&b, data, valuePos, fragmentFormat{escapeHTML: escapeHTML}, nil); err != nil {
+				return err
+			}
+			others = append(others, PatchOperation{Op: "replace", Path: path, Value: b.Bytes()})
+		case ModeDelete:
+			removals = append(removals, remove)
+		case ModeFilterElements:
+			elemRemovals = append(elemRemovals, remove)
+		case ModeMapElements:
+			value, err := encodePatchFragment(frag, escapeHTML)
+			if err != nil {
+				return err
+			}
+			others = append(others, PatchOperation{Op: "replace", Path: path, Value: value})
+		case ModeAppendElements:
+			if frag.fragment == nil {
+				break
+			}
+			value, err := encodePatchFragment(frag, escapeHTML)
+			if err != nil {
+				return err
+			}
+			tree, err := parseTree(value)
+			if err != nil {
+				return err
+			}
+			for _, elem := range tree.elements {
+				others = append(others, PatchOperation{Op: "add", Path: path + "/-", Value: elem.appendJSON(nil)})
+			}
+		}
+	}
+	*p = append(*p, removals...)
+	*p = append(*p, moves...)
+	*p = append(*p, others...)
+	for i := len(elemRemovals) - 1; i >= 0; i-- {
+		*p = append(*p, elemRemovals[i])
+	}
+	return nil
+}
+
+// encodePatchFragment returns compact json of fragment
+func encodePatchFragment(frag *fragEntry, escapeHTML bool) (json.RawMessage, error) {
+	var b bytes.Buffer
+	if err := frag.writeFragment(&b, fragmentFormat{escapeHTML: escapeHTML}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// patchMembers returns operations adding members of json object to parent
+func patchMembers(parent string, object []byte) (Patch, error) {
+	tree, err := parseTree(object)
+	if err != nil {
+		return nil, err
+	}
+	var ops Patch
+	for _, m := range tree.members {
+		ops = append(ops, PatchOperation{Op: "add", Path: parent + escapePointerToken(m.key), Value: m.value.appendJSON(nil)})
+	}
+	return ops, nil
+}
+
+// ApplyPatch returns json data changed by operations of patch, data isn't modified.
+// Operations add, remove, replace and move are supported.
+//
+// Data is scanned once: containers on paths of operations are split into members when they're reached first,
+// other values are copied to output as is once all operations are applied.
+func ApplyPatch(data []byte, patch Patch) ([]byte, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: syntax error", ErrInvalidJSON)
+	}
+	start := skipSpaces(data, 0)
+	end := len(bytes.TrimRight(data, " \t\r\n"))
+	root := &patchNode{raw: data[start:end]}
+	for i, op := range patch {
+		var err error
+		if root, err = applyPatchOperation(root, op); err != nil {
+			return nil, fmt.Errorf("unable to apply operation %d '%s %s': %w", i, op.Op, op.Path, err)
+		}
+	}
+	b := bytes.NewBuffer(make([]byte, 0, len(data)))
+	b.Write(data[:start])
+	root.write(b)
+	b.Write(data[end:])
+	return b.Bytes(), nil
+}
+
+// applyPatchOperation applies operation to value and returns the root value
+func applyPatchOperation(root *patchNode, op PatchOperation) (*patchNode, error) {
+	switch op.Op {
+	case "add", "replace":
+		if !json.Valid(op.Value) {
+			return nil, fmt.Errorf("%w: value", ErrInvalidJSON)
+		}
+		target, err := findPatchTarget(root, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "replace" && !target.exists {
+			return nil, errPatchPathNotFound
+		}
+		return target.set(root, &patchNode{raw: bytes.TrimSpace(op.Value)}, op.Op == "replace"), nil
+	case "remove":
+		target, err := findPatchTarget(root, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return root, target.remove()
+	case "move":
+		if op.From == op.Path {
+			return root, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, errors.New("value can't be moved into itself")
+		}
+		from, err := findPatchTarget(root, op.From)
+		if err != nil {
+			return nil, err
+		}
+		if !from.exists {
+			return nil, errPatchPathNotFound
+		}
+		value := from.value(root)
+		if err := from.remove(); err != nil {
+			return nil, err
+		}
+		target, err := findPatchTarget(root, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return target.set(root, value, false), nil
+	default:
+		return nil, errors.New("unsupported operation")
+	}
+}
+
+var errPatchPathNotFound = errors.New("path not found")
+
+// patchNode is json value patched by ApplyPatch, its bytes are written as is until container is split into items
+type patchNode struct {
+	raw    []byte // nil once container is split
+	object bool
+	items  []*patchItem
+	trail  []byte // spaces before closing bracket
+}
+
+// patchItem is object member or array element of split container
+type patchItem struct {
+	lead  []byte // spaces, key and colon written before value
+	key   string // decoded key of object member
+	value *patchNode
+}
+
+// split splits raw container into items, it's done once
+func (n *patchNode) split() {
+	if n.raw == nil {
+		return
+	}
+	data := n.raw
+	n.object = data[0] == '{'
+	lead := 1 // position of item's lead after opening bracket or comma
+	i := skipSpaces(data, lead)
+	for data[i] != '}' && data[i] != ']' {
+		item := &patchItem{}
+		if n.object {
+			end := i + findJSONStringEnd(data[i:]) + 1
+			item.key = decodeKey(data[i:end])
+			i = skipSpaces(data, skipSpaces(data, end)+1) // value after colon
+		}
+		end := i + findJSONFragmentEnd(data[i:])
+		item.lead, item.value = data[lead:i], &patchNode{raw: data[i:end]}
+		n.items = append(n.items, item)
+		if lead, i = end, skipSpaces(data, end); data[i] == ',' {
+			lead = i + 1
+			i = skipSpaces(data, lead)
+		}
+	}
+	n.trail = data[lead:i]
+	n.raw = nil
+}
+
+// write writes json of value to b
+func (n *patchNode) write(b *bytes.Buffer) {
+	if n.raw != nil {
+		b.Write(n.raw)
+		return
+	}
+	closing := byte(']')
+	if n.object {
+		closing = '}'
+	}
+	b.WriteByte(closing - 2) // '[' or '{'
+	for i, item := range n.items {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(item.lead)
+		item.value.write(b)
+	}
+	b.Write(n.trail)
+	b.WriteByte(closing)
+}
+
+// patchTarget is location of JSON Pointer in patched value
+type patchTarget struct {
+	container *patchNode // nil for the whole value
+	index     int        // index of item, it's number of items if target doesn't exist
+	key       string     // key of object member
+	exists    bool
+}
+
+// findPatchTarget returns location of JSON Pointer in root value, parent of the target must exist.
+// Containers on the path are split.
+func findPatchTarget(root *patchNode, pointer string) (*patchTarget, error) {
+	if pointer == "" {
+		return &patchTarget{exists: true}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errors.New("JSON Pointer should start with '/'")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	node := root
+	for n, token := range tokens {
+		if node.raw != nil && node.raw[0] != '{' && node.raw[0] != '[' {
+			return nil, errPatchPathNotFound // scalar value
+		}
+		node.split()
+		target := &patchTarget{container: node, index: len(node.items), key: unescapePointerToken(token)}
+		if err := target.findItem(); err != nil {
+			return nil, err
+		}
+		if n == len(tokens)-1 {
+			return target, nil
+		}
+		if !target.exists {
+			return nil, errPatchPathNotFound
+		}
+		node = node.items[target.index].value
+	}
+	panic("unexpected case: JSON Pointer has no tokens")
+}
+
+// findItem finds item of container by key or array index
+func (t *patchTarget) findItem() error {
+	c := t.container
+	if c.object {
+		for i := len(c.items) - 1; i >= 0; i-- {
+			if c.items[i].key == t.key {
+				t.index, t.exists = i, true // the last of duplicate keys
+				return nil
+			}
+		}
+		return nil
+	}
+	if t.key == "-" {
+		return nil
+	}
+	index, err := strconv.Atoi(t.key)
+	if err != nil || index < 0 || index > len(c.items) || (len(t.key) > 1 && t.key[0] == '0') {
+		return fmt.Errorf("invalid array index '%s'", t.key)
+	}
+	t.index, t.exists = index, index < len(c.items)
+	return nil
+}
+
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// unescapePointerToken unescapes reference token of JSON Pointer as per RFC 6901
+func unescapePointerToken(token string) string {
+	return pointerUnescaper.Replace(token)
+}
+
+// value returns existing value of target
+func (t *patchTarget) value(root *patchNode) *patchNode {
+	if t.container == nil {
+		return root
+	}
+	return t.container.items[t.index].value
+}
+
+// set replaces or adds value of target and returns the root value, added array elements are inserted before index
+func (t *patchTarget) set(root, value *patchNode, replace bool) *patchNode {
+	c := t.container
+	switch {
+	case c == nil:
+		return value
+	case t.exists && (c.object || replace):
+		c.items[t.index].value = value
+		return root
+	}
+	item := &patchItem{value: value}
+	if c.object {
+		item.key, item.lead = t.key, append(appendJSONString(nil, t.key), ':')
+	}
+	if t.index < len(c.items) { // inserted element takes spaces of the next one
+		item.lead, c.items[t.index].lead = c.items[t.index].lead, nil
+	}
+	c.items = append(c.items, nil)
+	copy(c.items[t.index+1:], c.items[t.index:])
+	c.items[t.index] = item
+	return root
+}
+
+// remove removes existing target
+func (t *patchTarget) remove() error {
+	if t.container == nil {
+		return errors.New("the whole data can't be removed")
+	}
+	if !t.exists {
+		return errPatchPathNotFound
+	}
+	c := t.container
+	c.items = append(c.items[:t.index], c.items[t.index+1:]...)
+	return nil
+}
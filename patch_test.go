@@ -0,0 +1,316 @@
+package jsonj
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProcess_patch(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		rules *RuleSet
+		want  string
+	}{
+		{
+			name:  "replace value",
+			input: `{"a": 1, "mark": 2}`,
+			rules: NewRuleSet(NewReplaceValueRule("mark", "mark", Constant("<x>"))),
+			want:  `[{"op":"replace","path":"/mark","value":"\u003cx\u003e"}]`,
+		},
+		{
+			name:  "rename",
+			input: `{"a": {"m/k": 2}}`,
+			rules: NewRuleSet(NewReplaceValueRule("m/k", "key", Constant(3))),
+			want:  `[{"op":"remove","path":"/a/m~1k"},{"op":"add","path":"/a/key","value":3}]`,
+		},
+		{
+			name:  "insert",
+			input: `{"mark": 1}`,
+			rules: NewRuleSet(NewInsertRule("mark", "key", Constant(map[string]int{"b": 2}))),
+			want:  `[{"op":"move","path":"/key","from":"/mark"},{"op":"add","path":"/b","value":2}]`,
+		},
+		{
+			name:  "removed elements in reverse order",
+			input: `{"list": ["a", 1, "b"]}`,
+			rules: NewRuleSet(NewFilterElementsRule("list", IsNumber)),
+			want:  `[{"op":"remove","path":"/list/2"},{"op":"remove","path":"/list/0"}]`,
+		},
+		{
+			name:  "removed before added",
+			input: `{"a": 1, "b": 2}`,
+			rules: NewRuleSet(NewReplaceValueRule("b", "a", Constant(3)), NewDeleteRule("a")),
+			want:  `[{"op":"remove","path":"/a"},{"op":"remove","path":"/b"},{"op":"add","path":"/a","value":3}]`,
+		},
+		{
+			name:  "append elements",
+			input: `{"list": [1]}`,
+			rules: NewRuleSet(NewAppendElementsRule("list", Constant([]int{2, 3}))),
+			want:  `[{"op":"add","path":"/list/-","value":2},{"op":"add","path":"/list/-","value":3}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch Patch
+			output, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{{RuleSet: tt.rules, Repeats: 1}},
+				Patch:  &patch,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(patch)
+			if string(got) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, got)
+			}
+
+			patched, err := ApplyPatch([]byte(tt.input), patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !jsonEqual(t, patched, output) {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", output, patched)
+			}
+		})
+	}
+}
+
+func TestProcess_patchDuplicateKeys(t *testing.T) {
+	const input = `{"uuid": "input", "key": 1}`
+	passes := []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("key", "uuid", Constant("gen"))), Repeats: 1}}
+
+	for _, policy := range []DuplicateKeysPolicy{DuplicateKeysKeepFirst, DuplicateKeysKeepLast} {
+		t.Run(policy.String(), func(t *testing.T) {
+			_, err := Process(context.Background(), []byte(input), ProcessParams{
+				Passes:        passes,
+				DuplicateKeys: policy,
+				Patch:         &Patch{},
+			})
+			const want = "patch of resolved duplicate keys isn't supported"
+			if err == nil || err.Error() != want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %v", want, err)
+			}
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		var patch Patch
+		output, err := Process(context.Background(), []byte(`{"id": "input", "key": 1}`), ProcessParams{
+			Passes:        passes,
+			DuplicateKeys: DuplicateKeysError,
+			Patch:         &patch,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		patched, err := ApplyPatch([]byte(`{"id": "input", "key": 1}`), patch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !jsonEqual(t, patched, output) {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %s", output, patched)
+		}
+	})
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		patch   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "add",
+			input: `{"a": [1, 2], "b": {}}`,
+			patch: `[{"op":"add","path":"/a/0","value":0},{"op":"add","path":"/a/-","value":3},` +
+				`{"op":"add","path":"/b/c~0","value":{}},{"op":"add","path":"/b/c~0/d","value":1},{"op":"add","path":"/a","value":[]}]`,
+			want: `{"a": [], "b": {"c~":{"d":1}}}`,
+		},
+		{
+			name:  "remove",
+			input: `{"a": [1, 2, 3], "b": 1, "c": 2}`,
+			patch: `[{"op":"remove","path":"/a/0"},{"op":"remove","path":"/a/1"},{"op":"remove","path":"/c"}]`,
+			want:  `{"a": [ 2], "b": 1}`,
+		},
+		{
+			name:  "replace",
+			input: ` [1, {"a": 2}] `,
+			patch: `[{"op":"replace","path":"/1/a","value":[3]},{"op":"replace","path":"/0","value":null}]`,
+			want:  ` [null, {"a": [3]}] `,
+		},
+		{
+			name:  "move",
+			input: `{"a": {"b": 1}, "c": []}`,
+			patch: `[{"op":"move","from":"/a/b","path":"/c/0"},{"op":"move","from":"/c","path":"/d"}]`,
+			want:  `{"a": {},"d":[1]}`,
+		},
+		{
+			name:  "root",
+			input: `{"a": 1}`,
+			patch: `[{"op":"replace","path":"","value":[1]}]`,
+			want:  `[1]`,
+		},
+		{
+			name:    "missing path",
+			input:   `{"a": 1}`,
+			patch:   `[{"op":"add","path":"/a/b/c","value":1}]`,
+			wantErr: "unable to apply operation 0 'add /a/b/c': path not found",
+		},
+		{
+			name:    "invalid index",
+			input:   `[1]`,
+			patch:   `[{"op":"remove","path":"/01"}]`,
+			wantErr: "unable to apply operation 0 'remove /01': invalid array index '01'",
+		},
+		{
+			name:    "move into itself",
+			input:   `{"a": {}}`,
+			patch:   `[{"op":"move","from":"/a","path":"/a/b"}]`,
+			wantErr: "unable to apply operation 0 'move /a/b': value can't be moved into itself",
+		},
+		{
+			name:    "unsupported operation",
+			input:   `{"a": 1}`,
+			patch:   `[{"op":"test","path":"/a","value":1}]`,
+			wantErr: "unable to apply operation 0 'test /a': unsupported operation",
+		},
+		{
+			name:    "invalid json",
+			input:   `{"a": [1}`,
+			patch:   `[{"op":"remove","path":"/a"}]`,
+			wantErr: "invalid json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch Patch
+			if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ApplyPatch([]byte(tt.input), patch)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("Not equal:\n  expected: %s\n  actual: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyPatch_random(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		var b strings.Builder
+		randomJSON(rng, &b, 4)
+		input := b.String()
+		var patch Patch
+		params := ProcessParams{
+			Passes:            []Pass{{RuleSet: randomRules(rng), Repeats: 1 + rng.Intn(3)}},
+			DisableHTMLEscape: rng.Intn(2) == 0,
+		}
+		duplicates := hasDuplicateKeys(t, []byte(input))
+		var output []byte
+		for repeats := 1; repeats <= params.Passes[0].Repeats && !duplicates; repeats++ {
+			repeatParams := params
+			repeatParams.Passes = []Pass{{RuleSet: params.Passes[0].RuleSet, Repeats: repeats}}
+			var err error
+			if output, err = Process(context.Background(), []byte(input), repeatParams); err != nil {
+				t.Fatalf("%v: %s", err, input)
+			}
+			duplicates = hasDuplicateKeys(t, output)
+		}
+		if duplicates {
+			continue // JSON Patch can't address duplicate keys
+		}
+		params.Patch = &patch
+		if _, err := Process(context.Background(), []byte(input), params); err != nil {
+			t.Fatalf("%v: %s", err, input)
+		}
+
+		patched, err := ApplyPatch([]byte(input), patch)
+		if err != nil {
+			t.Fatalf("%v: %s", err, input)
+		}
+		if !jsonEqual(t, patched, output) {
+			t.Fatalf("Not equal:\n  input: %s\n  expected: %s\n  actual: %s", input, output, patched)
+		}
+	}
+}
+
+// jsonEqual reports whether json values are equal ignoring order of members and formatting
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatalf("%v: %s", err, a)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func hasDuplicateKeys(t *testing.T, data []byte) bool {
+	t.Helper()
+	tree, err := parseTree(data)
+	if err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	var walk func(node *treeNode) bool
+	walk = func(node *treeNode) bool {
+		keys := make(map[string]bool, len(node.members))
+		for _, m := range node.members {
+			if keys[m.key] || walk(m.value) {
+				return true
+			}
+			keys[m.key] = true
+		}
+		for _, elem := range node.elements {
+			if walk(elem) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(tree)
+}
+
+func BenchmarkApplyPatch(b *testing.B) {
+	// large document with many operations is scanned once
+	var data strings.Builder
+	data.WriteString(`{"items": [`)
+	var patch Patch
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			data.WriteByte(',')
+		}
+		data.WriteString(`{"id": ` + strconv.Itoa(i) + `, "name": "item"}`)
+		if i%10 == 0 {
+			patch = append(patch, PatchOperation{Op: "replace", Path: "/items/" + strconv.Itoa(i) + "/name", Value: json.RawMessage(`"patched"`)})
+		}
+	}
+	data.WriteString(`]}`)
+	input := []byte(data.String())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ApplyPatch(input, patch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
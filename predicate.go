@@ -0,0 +1,63 @@
+package jsonj
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// Predicate reports whether rule applies to raw json value, the value has no leading and trailing spaces
+type Predicate func(raw []byte) bool
+
+// IsNull matches null values
+func IsNull(raw []byte) bool {
+	return bytes.Equal(raw, nullLiteral)
+}
+
+// IsString matches string values
+func IsString(raw []byte) bool {
+	return len(raw) > 0 && raw[0] == '"'
+}
+
+// IsNumber matches number values
+func IsNumber(raw []byte) bool {
+	return len(raw) > 0 && (raw[0] == '-' || raw[0] >= '0' && raw[0] <= '9')
+}
+
+// IsEmptyArray matches empty arrays
+func IsEmptyArray(raw []byte) bool {
+	return len(raw) > 1 && raw[0] == '[' && len(bytes.TrimSpace(raw[1:len(raw)-1])) == 0
+}
+
+// Equals returns predicate matching values equal to json encoding of v,
+// i.e. numbers are compared by value and objects regardless of keys order.
+// It panics if v can't be encoded.
+func Equals(v interface{}) Predicate {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var want interface{}
+	if err := json.Unmarshal(data, &want); err != nil {
+		panic(err)
+	}
+	return func(raw []byte) bool {
+		var value interface{}
+		return json.Unmarshal(raw, &value) == nil && reflect.DeepEqual(value, want)
+	}
+}
+
+// isObject matches object values
+func isObject(raw []byte) bool {
+	return len(raw) > 0 && raw[0] == '{'
+}
+
+// isArray matches array values
+func isArray(raw []byte) bool {
+	return len(raw) > 0 && raw[0] == '['
+}
+
+// isEmptyObject matches empty objects
+func isEmptyObject(raw []byte) bool {
+	return isObject(raw) && len(bytes.TrimSpace(raw[1:len(raw)-1])) == 0
+}
@@ -0,0 +1,65 @@
+package jsonj
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRule_When(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  *Rule
+		input string
+		want  string
+	}{
+		{
+			name:  "is null",
+			rule:  NewDeleteRule("deleted_at").When(IsNull),
+			input: `[{"id": 1, "deleted_at": null}, {"id": 2, "deleted_at": "2024-01-01"}]`,
+			want:  `[{"id": 1}, {"id": 2, "deleted_at": "2024-01-01"}]`,
+		},
+		{
+			name:  "is number",
+			rule:  NewReplaceValueRule("status", "status", Constant("unknown")).When(IsNumber),
+			input: `[{"status": 1}, {"status": "active"}, {"status": -1.5}]`,
+			want:  `[{"status":"unknown"}, {"status": "active"}, {"status":"unknown"}]`,
+		},
+		{
+			name:  "is string",
+			rule:  NewReplaceValueRule("id", "id", Constant(0)).When(IsString),
+			input: `[{"id": 1}, {"id": "a"}]`,
+			want:  `[{"id": 1}, {"id":0}]`,
+		},
+		{
+			name:  "is empty array",
+			rule:  NewDeleteRule("tags").When(IsEmptyArray),
+			input: `[{"tags": [ ]}, {"tags": [1]}, {"tags": {}}]`,
+			want:  `[{}, {"tags": [1]}, {"tags": {}}]`,
+		},
+		{
+			name:  "equals",
+			rule:  NewReplaceRule("kind", Constant(map[string]int{"version": 2})).When(Equals(map[string]int{"a": 1, "b": 2})),
+			input: `[{"kind": {"b": 2.0, "a": 1}}, {"kind": {"a": 1}}]`,
+			want:  `[{ "version":2}, {"kind": {"a": 1}}]`,
+		},
+		{
+			name:  "marks inside non-matching value",
+			rule:  NewDeleteRule("data").When(IsNull),
+			input: `{"data": {"data": null, "id": 1}}`,
+			want:  `{"data": { "id": 1}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(tt.rule), Repeats: 1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
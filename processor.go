@@ -0,0 +1,180 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// BufferPool keeps bytes buffers of Processor to be reused between calls
+type BufferPool interface {
+	// Get returns empty buffer or nil if pool has none
+	Get() *bytes.Buffer
+	// Put returns buffer to the pool, the buffer isn't used by Processor after
+	Put(buf *bytes.Buffer)
+}
+
+// syncBufferPool is BufferPool based on sync.Pool
+type syncBufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool returns BufferPool based on sync.Pool, it's safe for concurrent use
+func NewBufferPool() BufferPool {
+	return &syncBufferPool{}
+}
+
+func (p *syncBufferPool) Get() *bytes.Buffer {
+	buf, _ := p.pool.Get().(*bytes.Buffer)
+	return buf
+}
+
+func (p *syncBufferPool) Put(buf *bytes.Buffer) {
+	p.pool.Put(buf)
+}
+
+// Processor processes json data like Process with its own buffer pool and defaults of ProcessParams.
+// It's safe for concurrent use if its BufferPool and Hooks are.
+type Processor struct {
+	pool          BufferPool
+	sizeRatio     int // 0 means BufferSizeRatio
+	maxBufferSize int // 0 means MaxBufferSize
+	limits        Limits
+	hooks         Hooks
+}
+
+// ProcessorOption customizes Processor created by NewProcessor
+type ProcessorOption func(p *Processor)
+
+// WithBufferPool sets pool of buffers, processors may share one pool
+func WithBufferPool(pool BufferPool) ProcessorOption {
+	if pool == nil {
+		panic("pool is missing")
+	}
+	return func(p *Processor) {
+		p.pool = pool
+	}
+}
+
+// WithBufferSizeRatio sets ratio of initial buffer size to input size, 3 by default
+func WithBufferSizeRatio(ratio int) ProcessorOption {
+	if ratio <= 0 {
+		panic("ratio should be positive")
+	}
+	return func(p *Processor) {
+		p.sizeRatio = ratio
+	}
+}
+
+// WithMaxBufferSize sets capacity of the largest buffer returned to the pool, 5 MB by default.
+// Larger buffers are left to garbage collector.
+func WithMaxBufferSize(size int) ProcessorOption {
+	if size <= 0 {
+		panic("size should be positive")
+	}
+	return func(p *Processor) {
+		p.maxBufferSize = size
+	}
+}
+
+// WithLimits sets limits used if ProcessParams.Limits is zero
+func WithLimits(limits Limits) ProcessorOption {
+	return func(p *Processor) {
+		p.limits = limits
+	}
+}
+
+// WithHooks sets hooks used if ProcessParams.Hooks is nil
+func WithHooks(hooks Hooks) ProcessorOption {
+	return func(p *Processor) {
+		p.hooks = hooks
+	}
+}
+
+// NewProcessor creates Processor with its own buffer pool unless options set another one
+func NewProcessor(options ...ProcessorOption) *Processor {
+	p := &Processor{
+		pool:          NewBufferPool(),
+		sizeRatio:     3,
+		maxBufferSize: 5 * MB,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// defaultProcessor is used by Process, its buffers are sized by package variables
+var defaultProcessor = &Processor{pool: NewBufferPool()}
+
+// Process passes data changes using ProcessParams, see Processor.Process
+func Process(ctx context.Context, input []byte, params ProcessParams) ([]byte, error) {
+	return defaultProcessor.Process(ctx, input, params)
+}
+
+// AppendProcess appends output of Process to dst, see Processor.AppendProcess
+func AppendProcess(ctx context.Context, dst, input []byte, params ProcessParams) ([]byte, error) {
+	return defaultProcessor.AppendProcess(ctx, dst, input, params)
+}
+
+// ProcessTo writes output of Process to w, see Processor.ProcessTo
+func ProcessTo(ctx context.Context, w *bytes.Buffer, input []byte, params ProcessParams) error {
+	return defaultProcessor.ProcessTo(ctx, w, input, params)
+}
+
+// withDefaults returns params with limits and hooks of the processor if they aren't set
+func (p *Processor) withDefaults(params ProcessParams) ProcessParams {
+	if params.Limits == (Limits{}) {
+		params.Limits = p.limits
+	}
+	if params.Hooks == nil {
+		params.Hooks = p.hooks
+	}
+	return params
+}
+
+// newBuffer returns pool buffer of size grown depending on input size, but not more than output limit
+func (p *Processor) newBuffer(inputSize int, limits *Limits) *bytes.Buffer {
+	ratio := p.sizeRatio
+	if ratio == 0 {
+		ratio = BufferSizeRatio
+	}
+	size := inputSize * ratio
+	if limits.MaxOutputBytes > 0 && size > limits.MaxOutputBytes {
+		size = max(limits.MaxOutputBytes, inputSize)
+	}
+	buf := p.pool.Get()
+	if buf == nil {
+		return bytes.NewBuffer(make([]byte, 0, size))
+	}
+	buf.Grow(size)
+	return buf
+}
+
+// freeBuffer returns buffer to the pool unless it's too large
+func (p *Processor) freeBuffer(buf *bytes.Buffer) {
+	maxSize := p.maxBufferSize
+	if maxSize == 0 {
+		maxSize = MaxBufferSize
+	}
+	if buf.Cap() > maxSize {
+		return
+	}
+	buf.Reset()
+	p.pool.Put(buf)
+}
+
+const MB = 1 << 20
+
+var (
+	// BufferSizeRatio grows initial buffer size depends on input size.
+	//
+	// Deprecated: it's used by Process only, use WithBufferSizeRatio option of NewProcessor.
+	BufferSizeRatio = 3
+
+	// MaxBufferSize limits pool buffers size.
+	// Remember to increase if output json expected to be more
+	//
+	// Deprecated: it's used by Process only, use WithMaxBufferSize option of NewProcessor.
+	MaxBufferSize = 5 * MB
+)
@@ -0,0 +1,137 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// countingPool counts buffers taken from and returned to the pool
+type countingPool struct {
+	BufferPool
+	gets, puts int
+}
+
+func (p *countingPool) Get() *bytes.Buffer {
+	p.gets++
+	return p.BufferPool.Get()
+}
+
+func (p *countingPool) Put(buf *bytes.Buffer) {
+	p.puts++
+	p.BufferPool.Put(buf)
+}
+
+// doneHooks records stats of the last Process call
+type doneHooks struct {
+	NopHooks
+	stats *ProcessStats
+}
+
+func (h doneHooks) OnDone(stats ProcessStats, _ error) {
+	*h.stats = stats
+}
+
+func TestProcessor(t *testing.T) {
+	const input = `{"mark": 1}`
+	params := ProcessParams{
+		Passes: []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("mark", "key", Constant(2))), Repeats: 1}},
+	}
+
+	t.Run("buffer pool", func(t *testing.T) {
+		pool := &countingPool{BufferPool: NewBufferPool()}
+		p := NewProcessor(WithBufferPool(pool), WithBufferSizeRatio(1))
+		output, err := p.Process(context.Background(), []byte(input), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != `{"key":2}` {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %s", `{"key":2}`, output)
+		}
+		if pool.gets != 2 || pool.puts != 2 {
+			t.Errorf("2 gets and 2 puts expected, got %d gets and %d puts", pool.gets, pool.puts)
+		}
+	})
+
+	t.Run("max buffer size", func(t *testing.T) {
+		pool := &countingPool{BufferPool: NewBufferPool()}
+		p := NewProcessor(WithBufferPool(pool), WithMaxBufferSize(1))
+		if _, err := p.Process(context.Background(), []byte(input), params); err != nil {
+			t.Fatal(err)
+		}
+		if pool.puts != 0 {
+			t.Errorf("buffers larger than max size are put to the pool %d times", pool.puts)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		p := NewProcessor(WithLimits(Limits{MaxInputBytes: 5}))
+		_, err := p.Process(context.Background(), []byte(input), params)
+		var limitErr *LimitExceededError
+		if !errors.As(err, &limitErr) || limitErr.Limit != "MaxInputBytes" {
+			t.Errorf("MaxInputBytes limit error expected, got %v", err)
+		}
+
+		overridden := params
+		overridden.Limits = Limits{MaxInputBytes: 100}
+		if _, err := p.Process(context.Background(), []byte(input), overridden); err != nil {
+			t.Errorf("limits of params aren't used: %v", err)
+		}
+	})
+
+	t.Run("hooks", func(t *testing.T) {
+		var stats ProcessStats
+		p := NewProcessor(WithHooks(doneHooks{stats: &stats}))
+		if _, err := p.Process(context.Background(), []byte(input), params); err != nil {
+			t.Fatal(err)
+		}
+		want := ProcessStats{BytesIn: 11, BytesOut: 9, Repeats: 1}
+		if stats != want {
+			t.Errorf("Not equal:\n  expected: %+v\n  actual: %+v", want, stats)
+		}
+	})
+}
+
+func TestAppendProcess(t *testing.T) {
+	params := ProcessParams{
+		Passes: []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("mark", "key", Constant(2))), Repeats: 1}},
+	}
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "processed", input: `{"mark": 1}`, want: `[{"key":2}`},
+		{name: "as is", input: `{}`, want: `[{}`},
+		{name: "invalid", input: `{"mark": [1}`, want: `[`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &countingPool{BufferPool: NewBufferPool()}
+			p := NewProcessor(WithBufferPool(pool))
+
+			output, err := p.AppendProcess(context.Background(), []byte("["), []byte(tt.input), params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+
+			w := bytes.NewBufferString("[")
+			err = p.ProcessTo(context.Background(), w, []byte(tt.input), params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if w.String() != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, w)
+			}
+
+			if pool.gets != pool.puts {
+				t.Errorf("%d buffers are taken from the pool, %d are returned", pool.gets, pool.puts)
+			}
+		})
+	}
+}
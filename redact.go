@@ -0,0 +1,85 @@
+package jsonj
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// RedactStrategy returns replacement of raw json value of redacted mark
+type RedactStrategy func(raw []byte) interface{}
+
+// NewRedactRule creates rule replacing values of mark by strategy, the mark is kept.
+// Values redacted by a pass repeat would be redacted again by the next one,
+// so Process returns error if pass with Redact rules has more than one repeat.
+func NewRedactRule(mark string, strategy RedactStrategy) *Rule {
+	if strategy == nil {
+		panic("strategy is missing")
+	}
+	return NewRule(ModeRedact, mark, "", Func(func(raw []byte) (interface{}, error) {
+		return strategy(raw), nil
+	}))
+}
+
+// RedactFixed replaces values by string s
+func RedactFixed(s string) RedactStrategy {
+	return func([]byte) interface{} {
+		return s
+	}
+}
+
+// RedactKeepLast replaces values by string masked with '*' except of the last n characters.
+// Strings are masked unquoted, other values are masked as raw json.
+func RedactKeepLast(n int) RedactStrategy {
+	if n < 0 {
+		panic("n is negative")
+	}
+	return func(raw []byte) interface{} {
+		runes := []rune(rawString(raw))
+		masked := max(len(runes)-n, 0)
+		return strings.Repeat("*", masked) + string(runes[masked:])
+	}
+}
+
+// RedactHMAC replaces values by hex encoded HMAC-SHA256 with the key,
+// equal values have equal hashes. Strings are hashed unquoted, other values are hashed as raw json.
+// Hashed data is tagged by type, so string "1" and number 1 have different hashes.
+func RedactHMAC(key []byte) RedactStrategy {
+	if len(key) == 0 {
+		panic("key is missing")
+	}
+	return func(raw []byte) interface{} {
+		h := hmac.New(sha256.New, key)
+		var s string
+		if raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+			h.Write([]byte{'s'})
+			h.Write([]byte(s))
+		} else {
+			h.Write([]byte{'j'})
+			h.Write(raw)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+}
+
+// RedactZero replaces values by zero value of their type: "", 0, false, {} or [], null is kept
+func RedactZero() RedactStrategy {
+	return func(raw []byte) interface{} {
+		switch raw[0] {
+		case '"':
+			return ""
+		case 't', 'f':
+			return false
+		case 'n':
+			return nil
+		case '{':
+			return json.RawMessage("{}")
+		case '[':
+			return json.RawMessage("[]")
+		default:
+			return 0
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package jsonj
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestProcess_redact(t *testing.T) {
+	const input = `{"email": "pet@zoo.com", "card": 4111111111111111, "info": {"phone": "+7 (900) 000-12-34"}, ` +
+		`"extra": {"a": 1}, "verified": true, "deleted_at": null}`
+
+	tests := []struct {
+		name     string
+		strategy RedactStrategy
+		want     string
+	}{
+		{
+			name:     "fixed",
+			strategy: RedactFixed("***"),
+			want: `{"email":"***", "card":"***", "info": {"phone":"***"}, ` +
+				`"extra":"***", "verified":"***", "deleted_at":"***"}`,
+		},
+		{
+			name:     "keep last",
+			strategy: RedactKeepLast(4),
+			want: `{"email":"*******.com", "card":"************1111", "info": {"phone":"**************2-34"}, ` +
+				`"extra":"****: 1}", "verified":"true", "deleted_at":"null"}`,
+		},
+		{
+			name:     "hmac",
+			strategy: RedactHMAC([]byte("secret")),
+			want: `{"email":"b928d76168fdee9a6fa40163ffcf3e70eed35e64212045bbdffd798e5d065779", ` +
+				`"card":"498ea8b0ad4d2686fd1fadc8b9cdffacc2b74b3a0fa8cce51636095d9a524f6a", ` +
+				`"info": {"phone":"39efb9a9f3afebc9237e97454cf8d68001ac67ca52d22878529cd3c554105072"}, ` +
+				`"extra":"08406d9e38e54c60bb053053d0a951c13e6f93f3805178cc58fa55bc1a860f9a", ` +
+				`"verified":"161afdc313043fa95b9fdce05f1290dcb4b3b963083b823495a76de8bb92c4c8", ` +
+				`"deleted_at":"34fa02044d2adbee23229ad1c1d93b70e1b19844de821a6ff9ac4bad5c455ea9"}`,
+		},
+		{
+			name:     "zero",
+			strategy: RedactZero(),
+			want:     `{"email":"", "card":0, "info": {"phone":""}, "extra":{}, "verified":false, "deleted_at":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := NewRuleSet()
+			for _, mark := range []string{"email", "card", "phone", "extra", "verified", "deleted_at"} {
+				set.AddRule(NewRedactRule(mark, tt.strategy))
+			}
+			output, err := Process(context.Background(), []byte(input), ProcessParams{
+				Passes: []Pass{{RuleSet: set, Repeats: 1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestProcess_redactHMACTypes(t *testing.T) {
+	set := NewRuleSet(NewRedactRule("v", RedactHMAC([]byte("secret"))))
+	output, err := Process(context.Background(), []byte(`[{"v": "1"}, {"v": 1}, {"v": "\u0031"}]`), ProcessParams{
+		Passes: []Pass{{RuleSet: set, Repeats: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []struct{ V string }
+	if err := json.Unmarshal(output, &hashes); err != nil {
+		t.Fatal(err)
+	}
+	if hashes[0] == hashes[1] || hashes[0] != hashes[2] {
+		t.Errorf("string and number hashes should differ, equal strings should have equal hashes: %s", output)
+	}
+}
+
+func TestProcess_redactRepeats(t *testing.T) {
+	set := NewRuleSet(NewRedactRule("email", RedactHMAC([]byte("secret"))))
+	var outputs []string
+	for _, repeats := range []int{1, 2} {
+		output, err := Process(context.Background(), []byte(`{"email": "x@y"}`), ProcessParams{
+			Passes: []Pass{{RuleSet: set, Repeats: repeats}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, string(output))
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("value is redacted again by the next repeat:\n  expected: %s\n  actual: %s", outputs[0], outputs[1])
+	}
+}
@@ -0,0 +1,404 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ReferenceProcess is slow reference implementation of Process for verification.
+//
+// It decodes input by encoding/json to tree keeping order of object members, applies passes node by node
+// and encodes the tree compact. Output equals to output of Process up to insignificant whitespaces
+// and escaping of keys. Generators and predicates receive values as in input, like in Process.
+// ProcessParams other than Passes, Params and DisableHTMLEscape are ignored.
+func ReferenceProcess(ctx context.Context, input []byte, params ProcessParams) ([]byte, error) {
+	root, err := parseTree(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, pass := range params.Passes {
+		for i := 0; i < pass.Repeats; i++ {
+			r := &refRepeat{set: pass.RuleSet, first: i == 0, entries: make(map[*treeNode]*refEntry)}
+			r.scan(root)
+			if err := r.generate(ctx, &params); err != nil {
+				return nil, fmt.Errorf("unable to do pass %d: %w", i, err)
+			}
+			if root, err = r.rebuild(root, !params.DisableHTMLEscape); err != nil {
+				return nil, fmt.Errorf("unable to do pass %d: %w", i, err)
+			}
+		}
+	}
+	return root.appendJSON(nil), nil
+}
+
+// treeNode is json value decoded by encoding/json, members of objects are kept in order of input
+type treeNode struct {
+	kind     byte            // '{', '[' or 0 for scalars
+	raw      json.RawMessage // json of value as in input, it's nil for objects and arrays changed by rules
+	members  []treeMember
+	elements []*treeNode
+}
+
+// treeMember is key/value pair of object, key is decoded
+type treeMember struct {
+	key   string
+	value *treeNode
+}
+
+// parseTree decodes json data to tree
+func parseTree(data []byte) (*treeNode, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: syntax error", ErrInvalidJSON)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeTree(dec, data)
+}
+
+// decodeTree decodes the next value of dec reading data token by token, raw json of values is sliced from data
+func decodeTree(dec *json.Decoder, data []byte) (*treeNode, error) {
+	start := int(dec.InputOffset())
+	for start < len(data) && (asciiSpace[data[start]] == 1 || data[start] == ':' || data[start] == ',') {
+		start++ // separators before value aren't read by dec yet
+	}
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return &treeNode{raw: data[start:dec.InputOffset()]}, nil
+	}
+	n := &treeNode{kind: byte(delim)}
+	for dec.More() {
+		if n.kind == '[' {
+			elem, err := decodeTree(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			n.elements = append(n.elements, elem)
+			continue
+		}
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeTree(dec, data)
+		if err != nil {
+			return nil, err
+		}
+		n.members = append(n.members, treeMember{key: key.(string), value: value})
+	}
+	if _, err := dec.Token(); err != nil { // closing bracket
+		return nil, err
+	}
+	n.raw = data[start:dec.InputOffset()]
+	return n, nil
+}
+
+// rawJSON returns json of node as in input, like Process passes it to generators and predicates
+func (n *treeNode) rawJSON() []byte {
+	if n.raw != nil {
+		return n.raw
+	}
+	return n.appendJSON(nil)
+}
+
+// appendJSON appends compact json of node to b
+func (n *treeNode) appendJSON(b []byte) []byte {
+	switch n.kind {
+	case '{':
+		b = append(b, '{')
+		for i, m := range n.members {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, m.key)
+			b = append(b, ':')
+			b = m.value.appendJSON(b)
+		}
+		return append(b, '}')
+	case '[':
+		b = append(b, '[')
+		for i, elem := range n.elements {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = elem.appendJSON(b)
+		}
+		return append(b, ']')
+	default:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, n.raw); err != nil {
+			panic("invalid json: " + err.Error())
+		}
+		return append(b, buf.Bytes()...)
+	}
+}
+
+// refEntry is matched mark value or array element of a pass repeat
+type refEntry struct {
+	rule     *Rule
+	raw      []byte
+	fragment interface{}
+	key      string // key chosen by KeyValue fragment
+}
+
+// refRepeat applies rules of a pass repeat to tree
+type refRepeat struct {
+	set     *RuleSet
+	first   bool                    // rules keeping marks apply on the first repeat only
+	matched map[*treeNode]*Rule     // values of matched marks, they aren't scanned
+	entries map[*treeNode]*refEntry // entries by mark values and array elements
+	rules   []*Rule                 // rules in order of their first entries
+	batches map[*Rule][]*refEntry
+}
+
+// scan finds marks of node in order of input, values of matched marks are skipped
+func (r *refRepeat) scan(n *treeNode) {
+	for _, elem := range n.elements {
+		r.scan(elem)
+	}
+	for _, m := range n.members {
+		rule := r.set.rules[m.key]
+		if rule == nil || (!r.first && rule.keepsMark()) || !rule.matches(m.value.rawJSON()) {
+			r.scan(m.value)
+			continue
+		}
+		if r.matched == nil {
+			r.matched = make(map[*treeNode]*Rule)
+		}
+		r.matched[m.value] = rule
+		switch rule.mode {
+		case ModeMapElements, ModeFilterElements:
+			for _, elem := range m.value.elements {
+				raw := elem.rawJSON()
+				if rule.mode == ModeFilterElements && rule.element(raw) {
+					continue
+				}
+				r.add(elem, rule, raw)
+			}
+		default:
+			r.add(m.value, rule, m.value.rawJSON())
+		}
+	}
+}
+
+func (r *refRepeat) add(n *treeNode, rule *Rule, raw []byte) {
+	entry := &refEntry{rule: rule, raw: raw}
+	r.entries[n] = entry
+	if r.batches == nil {
+		r.batches = make(map[*Rule][]*refEntry)
+	}
+	if _, ok := r.batches[rule]; !ok {
+		r.rules = append(r.rules, rule)
+	}
+	r.batches[rule] = append(r.batches[rule], entry)
+}
+
+// generate calls generators of rules in order of their first entries
+func (r *refRepeat) generate(ctx context.Context, params *ProcessParams) error {
+	for _, rule := range r.rules {
+		batch := r.batches[rule]
+		result, err := rule.genBatch(ctx, &refEntryIter{entries: batch, idx: -1}, params.Params)
+		if err != nil {
+			return fmt.Errorf("fragments generation error for rule '%s': %w", rule, err)
+		}
+		if len(batch) != len(result) {
+			panic(fmt.Sprintf("unexpected case: %d != %d", len(batch), len(result)))
+		}
+		for i, entry := range batch {
+			entry.fragment = result[i]
+			kv, ok := result[i].(KeyValue)
+			if p, isPtr := result[i].(*KeyValue); isPtr && p != nil {
+				kv, ok = *p, true
+			}
+			if !ok {
+				continue
+			}
+			if rule.mode != ModeReplaceValue && rule.mode != ModeInsert {
+				panic(rule.mode.String() + " mode doesn't support KeyValue fragment")
+			}
+			if kv.Key == "" {
+				panic("KeyValue key is missing")
+			}
+			entry.key, entry.fragment = kv.Key, kv.Value
+		}
+	}
+	return nil
+}
+
+// rebuild returns copy of node with entries applied
+func (r *refRepeat) rebuild(n *treeNode, escapeHTML bool) (*treeNode, error) {
+	if n.kind == 0 {
+		return n, nil
+	}
+	out := &treeNode{kind: n.kind}
+	changed := false
+	for _, elem := range n.elements {
+		rebuilt, err := r.rebuild(elem, escapeHTML)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || rebuilt != elem
+		out.elements = append(out.elements, rebuilt)
+	}
+	for _, m := range n.members {
+		rule, ok := r.matched[m.value]
+		if !ok {
+			value, err := r.rebuild(m.value, escapeHTML)
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || value != m.value
+			out.members = append(out.members, treeMember{key: m.key, value: value})
+			continue
+		}
+		members, err := r.apply(m, rule, escapeHTML)
+		if err != nil {
+			return nil, fmt.Errorf("unable to apply rule '%s': %w", rule, err)
+		}
+		changed = true
+		out.members = append(out.members, members...)
+	}
+	if !changed {
+		return n, nil // raw json of node is kept
+	}
+	return out, nil
+}
+
+// apply returns members replacing matched member m
+func (r *refRepeat) apply(m treeMember, rule *Rule, escapeHTML bool) ([]treeMember, error) {
+	entry := r.entries[m.value]
+	switch rule.mode {
+	case ModeReplaceValue, ModeRedact:
+		value, err := encodeTree(entry.fragment, escapeHTML)
+		if err != nil {
+			return nil, err
+		}
+		return []treeMember{{key: entry.outputKey(), value: value}}, nil
+	case ModeReplace:
+		value, err := encodeTree(entry.fragment, escapeHTML)
+		if err != nil {
+			return nil, err
+		}
+		if value.kind != '{' {
+			return nil, fmt.Errorf("%s mode suspects object fragment, got %s", rule.mode, value.appendJSON(nil))
+		}
+		if len(value.members) == 0 {
+			return []treeMember{m}, nil // old member is kept
+		}
+		return value.members, nil
+	case ModeUnwrap:
+		return m.value.members, nil // members are written as is
+	case ModeInsert:
+		members, err := insertedMembers(entry.fragment, escapeHTML)
+		if err != nil {
+			return nil, err
+		}
+		return append([]treeMember{{key: entry.outputKey(), value: m.value}}, members...), nil
+	case ModeWrap:
+		members, err := insertedMembers(entry.fragment, escapeHTML)
+		if err != nil {
+			return nil, err
+		}
+		value := &treeNode{kind: '{', members: append([]treeMember{{key: entry.outputKey(), value: m.value}}, members...)}
+		return []treeMember{{key: m.key, value: value}}, nil
+	case ModeDelete:
+		return nil, nil
+	case ModeMapElements, ModeFilterElements:
+		value := &treeNode{kind: '['}
+		for _, elem := range m.value.elements {
+			entry, ok := r.entries[elem]
+			switch {
+			case !ok:
+				value.elements = append(value.elements, elem)
+			case rule.mode == ModeMapElements:
+				mapped, err := encodeTree(entry.fragment, escapeHTML)
+				if err != nil {
+					return nil, err
+				}
+				value.elements = append(value.elements, mapped)
+			}
+		}
+		return []treeMember{{key: m.key, value: value}}, nil
+	case ModeAppendElements:
+		appended, err := encodeTree(entry.fragment, escapeHTML)
+		if err != nil {
+			return nil, err
+		}
+		if appended.kind != '[' {
+			if bytes.Equal(appended.raw, nullLiteral) {
+				return []treeMember{m}, nil
+			}
+			return nil, fmt.Errorf("append elements mode suspects array fragment, got %s", appended.appendJSON(nil))
+		}
+		elements := append(append([]*treeNode(nil), m.value.elements...), appended.elements...)
+		return []treeMember{{key: m.key, value: &treeNode{kind: '[', elements: elements}}}, nil
+	}
+	panic("unknown mode value")
+}
+
+// outputKey returns key written instead of the mark
+func (e *refEntry) outputKey() string {
+	if e.key != "" {
+		return e.key
+	}
+	var key string
+	if err := json.Unmarshal([]byte(e.rule.preparedKey), &key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// insertedMembers returns members of Insert and Wrap fragment
+func insertedMembers(fragment interface{}, escapeHTML bool) ([]treeMember, error) {
+	value, err := encodeTree(fragment, escapeHTML)
+	if err != nil {
+		return nil, err
+	}
+	if value.kind != '{' {
+		return nil, fmt.Errorf("insert mode suspects object fragment, got %s", value.appendJSON(nil))
+	}
+	return value.members, nil
+}
+
+// encodeTree returns tree of fragment encoded by json.Encoder
+func encodeTree(fragment interface{}, escapeHTML bool) (*treeNode, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(escapeHTML)
+	if err := enc.Encode(fragment); err != nil {
+		return nil, fmt.Errorf("unable to encode fragment '%s': %v", fragment, err)
+	}
+	return parseTree(buf.Bytes())
+}
+
+// refEntryIter is FragmentIterator of ReferenceProcess entries
+type refEntryIter struct {
+	entries []*refEntry
+	idx     int
+}
+
+func (iter *refEntryIter) Next() bool {
+	iter.idx++
+	return iter.idx < len(iter.entries)
+}
+
+func (iter *refEntryIter) Count() int {
+	return len(iter.entries)
+}
+
+func (iter *refEntryIter) BindParams(v interface{}) error {
+	b := iter.entries[iter.idx].raw
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s, %v", b, err)
+	}
+	return nil
+}
+
+func (iter *refEntryIter) Bytes() []byte {
+	return iter.entries[iter.idx].raw
+}
@@ -0,0 +1,262 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReferenceProcess(t *testing.T) {
+	localize := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		var result []interface{}
+		for i := 0; iterator.Next(); i++ {
+			result = append(result, KeyValue{Key: "l" + strconv.Itoa(i%2), Value: string(iterator.Bytes())})
+		}
+		return result, nil
+	}
+	tests := []struct {
+		name  string
+		rules []*Rule
+		input string
+		want  string
+	}{
+		{
+			name:  "insert",
+			rules: []*Rule{NewInsertRule("a", "b", Constant(map[string]int{"c": 1}))},
+			input: `{"x": {"a": [1, {"a": 2}]}, "a": null}`,
+			want:  `{"x":{"b":[1,{"a":2}],"c":1},"b":null,"c":1}`,
+		},
+		{
+			name:  "replace",
+			rules: []*Rule{NewReplaceRule("a", Constant(map[string]int{"b": 1, "c": 2}))},
+			input: `[{"a": 0, "x": 1}, {"x": 1, "a": 0}]`,
+			want:  `[{"b":1,"c":2,"x":1},{"x":1,"b":1,"c":2}]`,
+		},
+		{
+			name:  "replace empty",
+			rules: []*Rule{NewReplaceRule("a", EmptyFragmentsGenerator)},
+			input: `{"a": 0}`,
+			want:  `{"a":0}`,
+		},
+		{
+			name:  "replace value by KeyValue",
+			rules: []*Rule{NewReplaceValueRule("a", "b", localize)},
+			input: `[{"a": 0}, {"a": "x"}]`,
+			want:  `[{"l0":"0"},{"l1":"\"x\""}]`,
+		},
+		{
+			name:  "delete and unwrap",
+			rules: []*Rule{NewDeleteRule("a"), NewUnwrapRule("b")},
+			input: `{"a": 0, "b": {"c": 1, "a": 2}, "d": {"b": {}}, "b": 1}`,
+			want:  `{"c":1,"a":2,"d":{},"b":1}`,
+		},
+		{
+			name: "elements",
+			rules: []*Rule{
+				NewMapElementsRule("a", Constant("e")),
+				NewFilterElementsRule("b", IsNumber),
+				NewAppendElementsRule("c", Constant([]int{1})),
+			},
+			input: `{"a": [1, [2]], "b": [1, "2", 3], "c": [], "a": {"b": []}}`,
+			want:  `{"a":["e","e"],"b":[1,3],"c":[1],"a":{"b":[]}}`,
+		},
+		{
+			name:  "wrap redact when",
+			rules: []*Rule{NewWrapRule("a", "v", Constant(map[string]int{"n": 1})), NewRedactRule("b", RedactFixed("***")).When(IsString)},
+			input: `{"a": {"b": "x"}, "c": {"b": 1, "b": "y"}}`,
+			want:  `{"a":{"v":{"b":"x"},"n":1},"c":{"b":1,"b":"***"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := ReferenceProcess(context.Background(), []byte(tt.input), ProcessParams{
+				Passes: []Pass{{RuleSet: NewRuleSet(tt.rules...), Repeats: 1}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestReferenceProcess_rawValues(t *testing.T) {
+	input := []byte(`{"a": {"c":  [1]}, "b": "\u0078", "b": "x"}`)
+	escaped := func(raw []byte) bool {
+		return bytes.IndexByte(raw, '\\') != -1
+	}
+	params := ProcessParams{Passes: []Pass{{
+		RuleSet: NewRuleSet(NewRedactRule("a", RedactKeepLast(4)), NewDeleteRule("b").When(escaped)),
+		Repeats: 1,
+	}}}
+	want, err := ReferenceProcess(context.Background(), input, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Process(context.Background(), input, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(want) != `{"a":"*******[1]}","b":"x"}` || normalizeJSON(t, got) != string(want) {
+		t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, got)
+	}
+}
+
+func TestReferenceProcess_replaceErrors(t *testing.T) {
+	for _, gen := range []GenerateFragmentBatchFunc{Null(), Constant("xy"), Constant(5)} {
+		_, err := ReferenceProcess(context.Background(), []byte(`{"a": 1, "b": 2}`), ProcessParams{
+			Passes: []Pass{{RuleSet: NewRuleSet(NewReplaceRule("a", gen)), Repeats: 1}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "Replace mode suspects object fragment") {
+			t.Errorf("error of object fragment expected, got %v", err)
+		}
+	}
+}
+
+// randomRules returns random rule set of marks a, b and c
+func randomRules(rng *rand.Rand) *RuleSet {
+	keyValue := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		var result []interface{}
+		for iterator.Next() {
+			result = append(result, KeyValue{Key: "kv", Value: len(compactJSON(iterator.Bytes()))})
+		}
+		return result, nil
+	}
+	predicates := []Predicate{IsNull, IsString, IsNumber, IsEmptyArray, Equals(1)}
+	set := NewRuleSet()
+	for i, mark := range []string{"a", "b", "c"} {
+		key := "k" + strconv.Itoa(i)
+		var rule *Rule
+		switch rng.Intn(13) {
+		case 0:
+			continue
+		case 1:
+			rule = NewInsertRule(mark, key, Constant(map[string]int{"i": i}))
+		case 2:
+			rule = NewInsertRule(mark, key, keyValueInsert)
+		case 3:
+			rule = NewDeleteRule(mark)
+		case 4:
+			rule = NewReplaceRule(mark, Constant(map[string]string{"r": mark, "a": mark}))
+		case 5:
+			rule = NewReplaceValueRule(mark, key, Constant([]string{mark}))
+		case 6:
+			rule = NewReplaceValueRule(mark, key, keyValue)
+		case 7:
+			rule = NewRedactRule(mark, RedactZero())
+		case 8:
+			rule = NewWrapRule(mark, mark, Constant(map[string]bool{"w": true}))
+		case 9:
+			rule = NewUnwrapRule(mark)
+		case 10:
+			rule = NewMapElementsRule(mark, Constant(map[string]int{"b": 1}))
+		case 11:
+			rule = NewFilterElementsRule(mark, predicates[rng.Intn(len(predicates))])
+		case 12:
+			rule = NewAppendElementsRule(mark, Constant([]interface{}{nil, map[string]int{"c": 1}}))
+		}
+		if rng.Intn(4) == 0 && rule.mode != ModeUnwrap && rule.when == nil {
+			rule.When(predicates[rng.Intn(len(predicates))])
+		}
+		set.AddRule(rule)
+	}
+	return set
+}
+
+// keyValueInsert is Insert generator choosing key by mark value
+func keyValueInsert(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+	var result []interface{}
+	for iterator.Next() {
+		result = append(result, &KeyValue{Key: "kv" + strconv.Itoa(len(compactJSON(iterator.Bytes()))%3), Value: map[string]int{"i": 0}})
+	}
+	return result, nil
+}
+
+// randomJSON writes random json value of depth not more than depth, keys of objects are mostly marks
+func randomJSON(rng *rand.Rand, b *strings.Builder, depth int) {
+	space := func() {
+		b.WriteString([]string{"", "", " ", "\n  "}[rng.Intn(4)])
+	}
+	kind := rng.Intn(8)
+	if depth == 0 {
+		kind %= 3 // scalar
+	}
+	switch kind {
+	case 0:
+		b.WriteString([]string{"null", "true", "false"}[rng.Intn(3)])
+	case 1:
+		b.WriteString([]string{"0", "1", "-2.5e3", "1E+2"}[rng.Intn(4)])
+	case 2:
+		b.WriteString([]string{`""`, `"x"`, `"a\"b"`, `"a"`, `"<&>"`}[rng.Intn(5)])
+	case 3, 4, 5:
+		b.WriteByte('[')
+		for i, n := 0, rng.Intn(4); i < n; i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			space()
+			randomJSON(rng, b, depth-1)
+		}
+		space()
+		b.WriteByte(']')
+	default:
+		b.WriteByte('{')
+		for i, n := 0, rng.Intn(5); i < n; i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			space()
+			b.WriteString([]string{`"a"`, `"b"`, `"c"`, `"b"`, `"x"`, `"k0"`}[rng.Intn(6)])
+			space()
+			b.WriteByte(':')
+			space()
+			randomJSON(rng, b, depth-1)
+		}
+		space()
+		b.WriteByte('}')
+	}
+}
+
+// normalizeJSON returns compact json with keys escaped by appendJSONString
+func normalizeJSON(t *testing.T, data []byte) string {
+	t.Helper()
+	tree, err := parseTree(data)
+	if err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	return string(tree.appendJSON(nil))
+}
+
+func TestReferenceProcess_random(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		var b strings.Builder
+		randomJSON(rng, &b, 4)
+		input := b.String()
+		params := ProcessParams{
+			Passes:            []Pass{{RuleSet: randomRules(rng), Repeats: 1 + rng.Intn(3)}},
+			DisableHTMLEscape: rng.Intn(2) == 0,
+			PreserveIndent:    rng.Intn(2) == 0, // formatting doesn't change semantic
+		}
+		if rng.Intn(3) == 0 {
+			params.Passes = append(params.Passes, Pass{RuleSet: randomRules(rng), Repeats: 1})
+		}
+
+		want, err := ReferenceProcess(context.Background(), []byte(input), params)
+		if err != nil {
+			t.Fatalf("%v: %s", err, input)
+		}
+		got, err := Process(context.Background(), []byte(input), params)
+		if err != nil {
+			t.Fatalf("%v: %s", err, input)
+		}
+		if normalizeJSON(t, got) != string(want) {
+			t.Fatalf("Not equal:\n  input: %s\n  expected: %s\n  actual: %s", input, want, got)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package jsonj
+
+import (
+	"bytes"
+	"sort"
+)
+
+// SourceMap maps output of Process back to its input, see ProcessParams.SourceMap.
+// It's rendered as JSON by json.Marshal.
+type SourceMap struct {
+	Spans []SourceSpan `json:"spans"` // adjacent spans covering the whole output
+
+	pass, repeat int // the current pass repeat starting from 1
+}
+
+// SourceSpan describes bytes of output copied from input or generated by a rule
+type SourceSpan struct {
+	Start  int    `json:"start"` // byte range of output
+	End    int    `json:"end"`
+	Input  int    `json:"input"`            // input offset of copied bytes, -1 for generated ones
+	Rule   string `json:"rule,omitempty"`   // rule of generated bytes
+	Mark   string `json:"mark,omitempty"`   // mark of the rule
+	Pass   int    `json:"pass,omitempty"`   // pass of generated bytes starting from 1
+	Repeat int    `json:"repeat,omitempty"` // repeat of the pass starting from 1
+}
+
+// Generated reports whether span is written by a rule
+func (s SourceSpan) Generated() bool {
+	return s.Input < 0
+}
+
+// Find returns span containing output offset
+func (m *SourceMap) Find(offset int) (SourceSpan, bool) {
+	i := sort.Search(len(m.Spans), func(i int) bool { return m.Spans[i].End > offset })
+	if offset < 0 || i == len(m.Spans) {
+		return SourceSpan{}, false
+	}
+	return m.Spans[i], true
+}
+
+// InputOffset returns input offset of output byte, it's false if the byte is generated
+func (m *SourceMap) InputOffset(offset int) (int, bool) {
+	span, ok := m.Find(offset)
+	if !ok || span.Generated() {
+		return 0, false
+	}
+	return span.Input + offset - span.Start, true
+}
+
+// reset maps input of size bytes to itself
+func (m *SourceMap) reset(size int) {
+	m.Spans = m.Spans[:0]
+	if size > 0 {
+		m.Spans = append(m.Spans, SourceSpan{Start: 0, End: size, Input: 0})
+	}
+}
+
+// newMapper returns mapper of the current pass repeat
+func (m *SourceMap) newMapper() *sourceMapper {
+	return &sourceMapper{pass: m.pass, repeat: m.repeat}
+}
+
+// compose maps spans of repeat output to input of Process through spans of repeat input
+func (m *SourceMap) compose(spans []SourceSpan) {
+	var result []SourceSpan
+	for _, s := range spans {
+		if s.Generated() {
+			result = appendSourceSpan(result, s)
+			continue
+		}
+		end := s.Input + s.End - s.Start
+		i := sort.Search(len(m.Spans), func(i int) bool { return m.Spans[i].End > s.Input })
+		for pos := s.Input; pos < end; i++ {
+			span := m.Spans[i] // span of repeat input containing pos
+			if span.Input >= 0 {
+				span.Input += pos - span.Start
+			}
+			span.Start = s.Start + pos - s.Input
+			pos = min(m.Spans[i].End, end)
+			span.End = s.Start + pos - s.Input
+			result = appendSourceSpan(result, span)
+		}
+	}
+	m.Spans = result
+}
+
+// appendSourceSpan appends span to spans, it's merged with the last span continued by it
+func appendSourceSpan(spans []SourceSpan, span SourceSpan) []SourceSpan {
+	if span.End == span.Start {
+		return spans
+	}
+	if len(spans) > 0 {
+		last := &spans[len(spans)-1]
+		copied := !last.Generated() && !span.Generated() && last.Input+last.End-last.Start == span.Input
+		generated := last.Generated() && span.Generated() && last.Rule == span.Rule && last.Mark == span.Mark &&
+			last.Pass == span.Pass && last.Repeat == span.Repeat
+		if copied || generated {
+			last.End = span.End
+			return spans
+		}
+	}
+	return append(spans, span)
+}
+
+// sourceMapper records spans of output written from data of a pass repeat,
+// output written between copies is generated by the current fragment.
+// Its methods write data as is if mapper is nil.
+type sourceMapper struct {
+	spans        []SourceSpan // relative to data
+	frag         *fragEntry
+	pass, repeat int
+}
+
+// write copies data from..to to b
+func (m *sourceMapper) write(b *bytes.Buffer, data []byte, from, to int) {
+	if m == nil {
+		b.Write(data[from:to])
+		return
+	}
+	start := b.Len()
+	m.generated(start)
+	b.Write(data[from:to])
+	m.spans = appendSourceSpan(m.spans, SourceSpan{Start: start, End: b.Len(), Input: from})
+}
+
+// writeReplacing copies data from..to to b replacing old by new, i.e. to change indentation of lines.
+// Replacements are generated by the current fragment.
+func (m *sourceMapper) writeReplacing(b *bytes.Buffer, data []byte, from, to int, old, new string) {
+	for {
+		i := bytes.Index(data[from:to], []byte(old))
+		if i == -1 {
+			break
+		}
+		m.write(b, data, from, from+i)
+		b.WriteString(new)
+		from += i + len(old)
+	}
+	m.write(b, data, from, to)
+}
+
+// next records output written up to end by the current fragment and switches to frag
+func (m *sourceMapper) next(frag *fragEntry, end int) {
+	if m == nil {
+		return
+	}
+	m.generated(end)
+	m.frag = frag
+}
+
+// generated records output written since the last span up to end by the current fragment
+func (m *sourceMapper) generated(end int) {
+	var start int
+	if len(m.spans) > 0 {
+		start = m.spans[len(m.spans)-1].End
+	}
+	if end <= start || m.frag == nil {
+		return
+	}
+	m.spans = appendSourceSpan(m.spans, SourceSpan{
+		Start:  start,
+		End:    end,
+		Input:  -1,
+		Rule:   m.frag.rule.String(),
+		Mark:   m.frag.rule.mark,
+		Pass:   m.pass,
+		Repeat: m.repeat,
+	})
+}
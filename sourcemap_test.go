@@ -0,0 +1,201 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcess_sourceMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		passes []Pass
+		indent bool
+		want   []SourceSpan
+	}{
+		{
+			name:   "replace value",
+			input:  `{"a": 1, "mark": 2}`,
+			passes: []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("mark", "key", Constant(3))), Repeats: 1}},
+			want: []SourceSpan{
+				{Start: 0, End: 9, Input: 0},
+				{Start: 9, End: 16, Input: -1, Rule: "ReplaceValue(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 16, End: 17, Input: 18},
+			},
+		},
+		{
+			name:   "insert",
+			input:  `{"mark": [1]}`,
+			passes: []Pass{{RuleSet: NewRuleSet(NewInsertRule("mark", "key", Constant(map[string]int{"b": 2}))), Repeats: 1}},
+			want: []SourceSpan{
+				{Start: 0, End: 1, Input: 0},
+				{Start: 1, End: 7, Input: -1, Rule: "Insert(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 7, End: 11, Input: 8},
+				{Start: 11, End: 17, Input: -1, Rule: "Insert(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 17, End: 18, Input: 12},
+			},
+		},
+		{
+			name:   "delete",
+			input:  `{"a": 1, "mark": 2, "b": 3}`,
+			passes: []Pass{{RuleSet: NewRuleSet(NewDeleteRule("mark")), Repeats: 1}},
+			want: []SourceSpan{
+				{Start: 0, End: 7, Input: 0},
+				{Start: 7, End: 16, Input: 18},
+			},
+		},
+		{
+			name:  "passes",
+			input: `{"a": 1, "b": 2}`,
+			passes: []Pass{
+				{RuleSet: NewRuleSet(NewReplaceValueRule("a", "c", Constant(map[string]int{"d": 3}))), Repeats: 1},
+				{RuleSet: NewRuleSet(NewDeleteRule("d"), NewReplaceValueRule("b", "b", Constant(4))), Repeats: 1},
+			},
+			want: []SourceSpan{
+				{Start: 0, End: 1, Input: 0},
+				{Start: 1, End: 7, Input: -1, Rule: "ReplaceValue(a)", Mark: "a", Pass: 1, Repeat: 1},
+				{Start: 7, End: 9, Input: 7},
+				{Start: 9, End: 14, Input: -1, Rule: "ReplaceValue(b)", Mark: "b", Pass: 2, Repeat: 1},
+				{Start: 14, End: 15, Input: 15},
+			},
+		},
+		{
+			name:   "unwrap indented",
+			input:  "{\n  \"meta\": {\n    \"a\": 1,\n    \"b\": 2\n  }\n}",
+			passes: []Pass{{RuleSet: NewRuleSet(NewUnwrapRule("meta")), Repeats: 1}},
+			indent: true,
+			want: []SourceSpan{
+				{Start: 0, End: 4, Input: 0},
+				{Start: 4, End: 11, Input: 18},
+				{Start: 11, End: 14, Input: -1, Rule: "Unwrap(meta)", Mark: "meta", Pass: 1, Repeat: 1},
+				{Start: 14, End: 20, Input: 30},
+				{Start: 20, End: 22, Input: 40},
+			},
+		},
+		{
+			name:   "wrap indented",
+			input:  "{\n  \"mark\": [\n    1\n  ]\n}",
+			passes: []Pass{{RuleSet: NewRuleSet(NewWrapRule("mark", "v", Constant(map[string]int{"n": 2}))), Repeats: 1}},
+			indent: true,
+			want: []SourceSpan{
+				{Start: 0, End: 12, Input: 0},
+				{Start: 12, End: 23, Input: -1, Rule: "Wrap(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 23, End: 24, Input: 12},
+				{Start: 24, End: 27, Input: -1, Rule: "Wrap(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 27, End: 32, Input: 14},
+				{Start: 32, End: 35, Input: -1, Rule: "Wrap(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 35, End: 38, Input: 20},
+				{Start: 38, End: 54, Input: -1, Rule: "Wrap(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+				{Start: 54, End: 56, Input: 23},
+			},
+		},
+		{
+			name:  "as is",
+			input: `{"a": 1}`,
+			want:  []SourceSpan{{Start: 0, End: 8, Input: 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := &SourceMap{}
+			output, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes:         tt.passes,
+				PreserveIndent: tt.indent,
+				SourceMap:      sources,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sources.Spans, tt.want) {
+				t.Errorf("Not equal:\n  expected: %+v\n  actual: %+v\n  output: %s", tt.want, sources.Spans, output)
+			}
+			checkSourceMap(t, []byte(tt.input), output, sources)
+		})
+	}
+
+	t.Run("formatted output", func(t *testing.T) {
+		_, err := Process(context.Background(), []byte(`{"a": 1}`), ProcessParams{Output: OutputCompact, SourceMap: &SourceMap{}})
+		const want = "source map of formatted output isn't supported"
+		if err == nil || err.Error() != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %v", want, err)
+		}
+	})
+}
+
+func TestSourceMap_InputOffset(t *testing.T) {
+	sources := &SourceMap{Spans: []SourceSpan{
+		{Start: 0, End: 9, Input: 0},
+		{Start: 9, End: 16, Input: -1, Rule: "ReplaceValue(mark)", Mark: "mark", Pass: 1, Repeat: 1},
+		{Start: 16, End: 17, Input: 18},
+	}}
+	tests := []struct {
+		offset int
+		want   int
+		wantOk bool
+	}{
+		{offset: 3, want: 3, wantOk: true},
+		{offset: 9},
+		{offset: 16, want: 18, wantOk: true},
+		{offset: 17},
+		{offset: -1},
+	}
+	for _, tt := range tests {
+		got, ok := sources.InputOffset(tt.offset)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("offset %d: expected %d, %v, got %d, %v", tt.offset, tt.want, tt.wantOk, got, ok)
+		}
+	}
+}
+
+func TestProcess_sourceMapRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		var b strings.Builder
+		randomJSON(rng, &b, 4)
+		input := b.String()
+		sources := &SourceMap{}
+		params := ProcessParams{
+			Passes:         []Pass{{RuleSet: randomRules(rng), Repeats: 1 + rng.Intn(3)}},
+			PreserveIndent: rng.Intn(2) == 0,
+			DuplicateKeys:  []DuplicateKeysPolicy{DuplicateKeysAllow, DuplicateKeysKeepFirst, DuplicateKeysKeepLast}[rng.Intn(3)],
+			SourceMap:      sources,
+		}
+		if rng.Intn(3) == 0 {
+			params.Passes = append(params.Passes, Pass{RuleSet: randomRules(rng), Repeats: 1})
+		}
+		output, err := Process(context.Background(), []byte(input), params)
+		if err != nil {
+			t.Fatalf("%v: %s", err, input)
+		}
+		checkSourceMap(t, []byte(input), output, sources)
+	}
+}
+
+// checkSourceMap checks that spans cover the whole output and copied spans are equal to input
+func checkSourceMap(t *testing.T, input, output []byte, sources *SourceMap) {
+	t.Helper()
+	var pos int
+	for _, span := range sources.Spans {
+		if span.Start != pos || span.End <= span.Start {
+			t.Fatalf("span %+v doesn't continue output at %d: %s", span, pos, output)
+		}
+		pos = span.End
+		if span.Generated() {
+			if span.Rule == "" || span.Mark == "" || span.Pass == 0 || span.Repeat == 0 {
+				t.Fatalf("rule of generated span %+v is missing", span)
+			}
+			continue
+		}
+		inputEnd := span.Input + span.End - span.Start
+		if inputEnd > len(input) || !bytes.Equal(output[span.Start:span.End], input[span.Input:inputEnd]) {
+			t.Fatalf("span %+v isn't copied from input:\n  input: %s\n  output: %s", span, input, output)
+		}
+	}
+	if pos != len(output) {
+		t.Fatalf("spans cover %d bytes of %d: %s", pos, len(output), output)
+	}
+}
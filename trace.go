@@ -0,0 +1,136 @@
+package jsonj
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Trace records rewrites done by Process, see ProcessParams.Trace.
+// It's rendered as text by WriteText and as JSON by json.Marshal.
+type Trace struct {
+	Repeats []TraceRepeat `json:"repeats"`
+}
+
+// TraceRepeat describes rewrites of a pass repeat
+type TraceRepeat struct {
+	Pass     int            `json:"pass"`   // starting from 1
+	Repeat   int            `json:"repeat"` // starting from 1
+	Rewrites []TraceRewrite `json:"rewrites"`
+	Calls    []TraceCall    `json:"calls"`
+}
+
+// TraceRewrite describes rewrite of matched mark or array element
+type TraceRewrite struct {
+	Rule     string          `json:"rule"`
+	Path     string          `json:"path"`  // JSON Pointer (RFC 6901) of the value
+	Start    int             `json:"start"` // byte range of the mark and its value in the repeat input
+	End      int             `json:"end"`
+	Value    json.RawMessage `json:"value"`              // input value
+	Key      string          `json:"key,omitempty"`      // key written instead of the mark, i.e. key of KeyValue
+	Fragment json.RawMessage `json:"fragment,omitempty"` // generated fragment, empty for removals
+}
+
+// TraceCall describes generator call
+type TraceCall struct {
+	Rule     string        `json:"rule"`
+	Count    int           `json:"count"`    // number of fragments
+	Duration time.Duration `json:"duration"` // nanoseconds in JSON
+}
+
+// addCall records generator call of the current repeat
+func (t *Trace) addCall(rule *Rule, count int, d time.Duration) {
+	r := &t.Repeats[len(t.Repeats)-1]
+	r.Calls = append(r.Calls, TraceCall{Rule: rule.String(), Count: count, Duration: d})
+}
+
+// addRewrites records fragments of the current repeat
+func (t *Trace) addRewrites(data []byte, fragments []*fragEntry) {
+	paths := valuePaths(data)
+	r := &t.Repeats[len(t.Repeats)-1]
+	for _, frag := range fragments {
+		valuePos := skipSpaces(data, frag.argsPos)
+		rewrite := TraceRewrite{
+			Rule:  frag.rule.String(),
+			Path:  paths[valuePos],
+			Start: frag.markPos,
+			End:   frag.endPos,
+			Value: append(json.RawMessage(nil), data[valuePos:frag.endPos]...),
+		}
+		switch frag.rule.mode {
+		case ModeReplaceValue, ModeInsert, ModeWrap:
+			rewrite.Key = decodeKey([]byte(frag.preparedKey()))
+		}
+		if frag.rule.mode != ModeDelete && frag.rule.mode != ModeFilterElements {
+			rewrite.Fragment = traceFragment(frag.fragment)
+		}
+		r.Rewrites = append(r.Rewrites, rewrite)
+	}
+}
+
+// traceFragment returns json of fragment, it's a string describing error if fragment can't be encoded
+func traceFragment(fragment interface{}) json.RawMessage {
+	data, err := json.Marshal(fragment)
+	if err != nil {
+		return json.RawMessage(strconv.Quote("unable to encode fragment: " + err.Error()))
+	}
+	return data
+}
+
+// valuePaths returns JSON Pointers of members and elements by positions of their values
+func valuePaths(data []byte) map[int]string {
+	paths := make(map[int]string)
+	_ = walkContainers(data, func(c *jsonContainer) error {
+		for i, m := range c.members {
+			token := m.key
+			if !c.object {
+				token = strconv.Itoa(i)
+			}
+			paths[m.valuePos] = c.path + "/" + escapePointerToken(token)
+		}
+		return nil
+	})
+	return paths
+}
+
+// WriteText writes trace as text:
+//
+//	pass 1, repeat 1:
+//	  ReplaceValue(pet_id) at /0/pet_id [1:13]: 1 -> "74ea3f44-ba35-4d2d-8a3e-01fb4c458df4"
+//	  generator ReplaceValue(pet_id): 1 fragments in 12µs
+func (t *Trace) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	for _, r := range t.Repeats {
+		fmt.Fprintf(&buf, "pass %d, repeat %d:\n", r.Pass, r.Repeat)
+		if len(r.Rewrites) == 0 {
+			buf.WriteString("  no marks found\n")
+		}
+		for _, rw := range r.Rewrites {
+			fmt.Fprintf(&buf, "  %s at %s [%d:%d]: %s", rw.Rule, rw.Path, rw.Start, rw.End, compactJSON(rw.Value))
+			switch {
+			case rw.Key != "":
+				fmt.Fprintf(&buf, " -> %s: %s", strconv.Quote(rw.Key), compactJSON(rw.Fragment))
+			case rw.Fragment != nil:
+				fmt.Fprintf(&buf, " -> %s", compactJSON(rw.Fragment))
+			}
+			buf.WriteByte('\n')
+		}
+		for _, c := range r.Calls {
+			fmt.Fprintf(&buf, "  generator %s: %d fragments in %s\n", c.Rule, c.Count, c.Duration)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// compactJSON returns json without insignificant whitespaces
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,79 @@
+package jsonj
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestProcess_trace(t *testing.T) {
+	trace := &Trace{}
+	_, err := Process(context.Background(), []byte(`[{"id": 1, "tags": [1, "a"], "secret": {"a": 1}}, {"id": 2}]`), ProcessParams{
+		Passes: []Pass{{
+			RuleSet: NewRuleSet(
+				NewReplaceValueRule("id", "uuid", MapLookup(map[int]string{1: "a1"}, MissingNull)),
+				NewFilterElementsRule("tags", IsNumber),
+				NewDeleteRule("secret"),
+			),
+			Repeats: 2,
+		}},
+		Trace: trace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range trace.Repeats {
+		for j := range trace.Repeats[i].Calls {
+			trace.Repeats[i].Calls[j].Duration = 0
+		}
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := trace.WriteText(&buf); err != nil {
+			t.Fatal(err)
+		}
+		const want = `pass 1, repeat 1:
+  ReplaceValue(id) at /0/id [2:9]: 1 -> "uuid": "a1"
+  FilterElements(tags) at /0/tags/1 [23:26]: "a"
+  Delete(secret) at /0/secret [29:47]: {"a":1}
+  ReplaceValue(id) at /1/id [51:58]: 2 -> "uuid": null
+  generator ReplaceValue(id): 2 fragments in 0s
+  generator FilterElements(tags): 1 fragments in 0s
+  generator Delete(secret): 1 fragments in 0s
+pass 1, repeat 2:
+  no marks found
+`
+		if buf.String() != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, buf.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(trace.Repeats[0].Rewrites[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		const want = `{"rule":"ReplaceValue(id)","path":"/0/id","start":2,"end":9,"value":1,"key":"uuid","fragment":"a1"}`
+		if string(data) != want {
+			t.Errorf("Not equal:\n  expected: %s\n  actual: %s", want, data)
+		}
+	})
+	t.Run("key value", func(t *testing.T) {
+		trace := &Trace{}
+		localize := Func(func(raw []byte) (interface{}, error) {
+			return KeyValue{Key: "title_en", Value: json.RawMessage(raw)}, nil
+		})
+		output, err := Process(context.Background(), []byte(`{"title": "Cat"}`), ProcessParams{
+			Passes: []Pass{{RuleSet: NewRuleSet(NewReplaceValueRule("title", "title", localize)), Repeats: 1}},
+			Trace:  trace,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key := trace.Repeats[0].Rewrites[0].Key; key != "title_en" {
+			t.Errorf("Not equal:\n  expected: title_en\n  actual: %s\n  output: %s", key, output)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package jsonj
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// DuplicateKeysPolicy determines how Process handles objects with duplicate keys in the output
+type DuplicateKeysPolicy int
+
+const (
+	DuplicateKeysAllow     DuplicateKeysPolicy = iota // output is not validated
+	DuplicateKeysError                                // Process fails with *DuplicateKeyError
+	DuplicateKeysKeepFirst                            // the first key/value pair is kept, others are removed
+	DuplicateKeysKeepLast                             // the last key/value pair is kept, others are removed
+)
+
+func (p DuplicateKeysPolicy) String() string {
+	switch p {
+	case DuplicateKeysAllow:
+		return "Allow"
+	case DuplicateKeysError:
+		return "Error"
+	case DuplicateKeysKeepFirst:
+		return "KeepFirst"
+	case DuplicateKeysKeepLast:
+		return "KeepLast"
+	default:
+		panic("unknown duplicate keys policy value")
+	}
+}
+
+// DuplicateKeyError describes the same key found twice in one object of the output
+type DuplicateKeyError struct {
+	Path      string // JSON Pointer of the duplicated key
+	Key       string
+	FirstPos  int // position of the first key in the output
+	SecondPos int // position of the second key in the output
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("duplicate key '%s' at %s: positions %d and %d", e.Key, e.Path, e.FirstPos, e.SecondPos)
+}
+
+// resolveDuplicateKeys writes data to b handling duplicate keys as per policy
+func resolveDuplicateKeys(b *bytes.Buffer, data []byte, policy DuplicateKeysPolicy, sources *sourceMapper) error {
+	var spans [][2]int // removed parts of data
+	err := walkContainers(data, func(c *jsonContainer) error {
+		if !c.object || len(c.members) < 2 {
+			return nil
+		}
+		seen := make(map[string]int, len(c.members))
+		var removed []bool
+		for i, m := range c.members {
+			first, dup := seen[m.key]
+			if !dup {
+				seen[m.key] = i
+				continue
+			}
+			if policy == DuplicateKeysError {
+				return &DuplicateKeyError{
+					Path:      c.path + "/" + escapePointerToken(m.key),
+					Key:       m.key,
+					FirstPos:  c.members[first].keyPos,
+					SecondPos: m.keyPos,
+				}
+			}
+			if removed == nil {
+				removed = make([]bool, len(c.members))
+			}
+			if policy == DuplicateKeysKeepFirst {
+				removed[i] = true
+			} else {
+				removed[first] = true
+				seen[m.key] = i
+			}
+		}
+		if removed != nil {
+			spans = appendRemovedMembers(spans, c.members, removed)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// spans of nested objects are reported before spans of their parents
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	var pos int
+	for _, span := range spans {
+		if span[0] < pos { // inside of already removed member
+			continue
+		}
+		sources.write(b, data, pos, span[0])
+		pos = span[1]
+	}
+	sources.write(b, data, pos, len(data))
+	return nil
+}
+
+// appendRemovedMembers appends spans of removed object members including their commas.
+// At least one member is expected to be kept.
+func appendRemovedMembers(spans [][2]int, members []jsonMember, removed []bool) [][2]int {
+	kept := 0
+	for removed[kept] {
+		kept++
+	}
+	if kept > 0 { // leading members are removed with their trailing commas
+		spans = append(spans, [2]int{members[0].keyPos, members[kept].keyPos})
+	}
+	for i := kept + 1; i < len(members); i++ {
+		if removed[i] { // others are removed with their leading commas
+			spans = append(spans, [2]int{members[i-1].endPos, members[i].endPos})
+		}
+	}
+	return spans
+}
@@ -0,0 +1,110 @@
+package jsonj
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProcess_duplicateKeys(t *testing.T) {
+	generateUUID := func(_ context.Context, iterator FragmentIterator, _ interface{}) ([]interface{}, error) {
+		type Output struct {
+			UUID string `json:"uuid"`
+		}
+		var entities []interface{}
+		for iterator.Next() {
+			entities = append(entities, Output{UUID: "generated"})
+		}
+		return entities, nil
+	}
+	passes := []Pass{{
+		RuleSet: NewRuleSet(NewInsertRule("mark", "key", generateUUID)),
+		Repeats: 1,
+	}}
+
+	tests := []struct {
+		name   string
+		policy DuplicateKeysPolicy
+		input  string
+		want   string
+	}{
+		{
+			name:   "allow",
+			policy: DuplicateKeysAllow,
+			input:  `{"uuid": "input", "mark": 1}`,
+			want:   `{"uuid": "input", "key": 1,"uuid":"generated"}`,
+		},
+		{
+			name:   "keep first",
+			policy: DuplicateKeysKeepFirst,
+			input:  `{"uuid": "input", "mark": 1}`,
+			want:   `{"uuid": "input", "key": 1}`,
+		},
+		{
+			name:   "keep last",
+			policy: DuplicateKeysKeepLast,
+			input:  `{"uuid": "input", "mark": 1}`,
+			want:   `{"key": 1,"uuid":"generated"}`,
+		},
+		{
+			name:   "keep first of many",
+			policy: DuplicateKeysKeepFirst,
+			input:  `{"a": 1, "a": 2, "b": 3, "a": 4}`,
+			want:   `{"a": 1, "b": 3}`,
+		},
+		{
+			name:   "keep last of many",
+			policy: DuplicateKeysKeepLast,
+			input:  `{"a": 1, "a": 2, "b": 3, "a": 4}`,
+			want:   `{"b": 3, "a": 4}`,
+		},
+		{
+			name:   "nested objects",
+			policy: DuplicateKeysKeepLast,
+			input:  `[{"a": {"b": 1, "b": 2}}, {"a": [{"c": 1, "c": 2}], "a": null}]`,
+			want:   `[{"a": {"b": 2}}, {"a": null}]`,
+		},
+		{
+			name:   "escaped key",
+			policy: DuplicateKeysKeepFirst,
+			input:  `{"a/b": 1, "a\/b": 2}`,
+			want:   `{"a/b": 1}`,
+		},
+		{
+			name:   "no duplicates",
+			policy: DuplicateKeysError,
+			input:  `{"a": {"a": [{"a": 1}]}}`,
+			want:   `{"a": {"a": [{"a": 1}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Process(context.Background(), []byte(tt.input), ProcessParams{
+				Passes:        passes,
+				DuplicateKeys: tt.policy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Not equal:\n  expected: %s\n  actual: %s", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		input := `[0, {"uuid": "input", "mark": 1}]`
+		_, err := Process(context.Background(), []byte(input), ProcessParams{
+			Passes:        passes,
+			DuplicateKeys: DuplicateKeysError,
+		})
+		var dupErr *DuplicateKeyError
+		if !errors.As(err, &dupErr) {
+			t.Fatalf("DuplicateKeyError expected, got %v", err)
+		}
+		want := DuplicateKeyError{Path: "/1/uuid", Key: "uuid", FirstPos: 5, SecondPos: 31}
+		if *dupErr != want {
+			t.Errorf("Not equal:\n  expected: %+v\n  actual: %+v", want, *dupErr)
+		}
+	})
+}
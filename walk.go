@@ -0,0 +1,117 @@
+package jsonj
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// jsonMember describes key/value pair of json object or element of json array
+type jsonMember struct {
+	key      string // decoded key, empty for array elements
+	keyPos   int    // position of key opening quote, -1 for array elements
+	valuePos int    // position of value first char
+	endPos   int    // position after value last char
+}
+
+// jsonContainer describes json object or array found by walkContainers
+type jsonContainer struct {
+	path     string // JSON Pointer (RFC 6901) of the container
+	object   bool
+	startPos int // position of opening bracket
+	endPos   int // position after closing bracket
+	members  []jsonMember
+}
+
+// walkContainers iterates over all objects and arrays of json data.
+//
+// It uses explicit stack instead of recursion, so nesting depth is limited by memory only.
+// Nested containers are passed to callback before their parents.
+func walkContainers(data []byte, callback func(c *jsonContainer) error) error {
+	i := skipSpaces(data, 0)
+	if i == len(data) || (data[i] != '{' && data[i] != '[') {
+		return nil // scalar value
+	}
+	stack := []*jsonContainer{{object: data[i] == '{', startPos: i}}
+	i++
+	for len(stack) > 0 {
+		c := stack[len(stack)-1]
+		if i = skipSpaces(data, i); i == len(data) {
+			panic("invalid json: unexpected end of data")
+		}
+		switch data[i] {
+		case ',':
+			i++
+			continue
+		case '}', ']':
+			i++
+			c.endPos = i
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.members[len(parent.members)-1].endPos = i
+			}
+			if err := callback(c); err != nil {
+				return err
+			}
+			continue
+		}
+
+		m := jsonMember{keyPos: -1}
+		var token string
+		if c.object {
+			m.keyPos = i
+			end := i + findJSONStringEnd(data[i:]) + 1
+			m.key = decodeKey(data[i:end])
+			token = m.key
+			if i = skipSpaces(data, end); i == len(data) || data[i] != ':' {
+				panic("invalid json: colon expected after key " + m.key)
+			}
+			i = skipSpaces(data, i+1)
+		} else {
+			token = strconv.Itoa(len(c.members))
+		}
+		m.valuePos = i
+		c.members = append(c.members, m)
+		if i < len(data) && (data[i] == '{' || data[i] == '[') {
+			stack = append(stack, &jsonContainer{
+				path:     c.path + "/" + escapePointerToken(token),
+				object:   data[i] == '{',
+				startPos: i,
+			})
+			i++
+			continue
+		}
+		i += findJSONFragmentEnd(data[i:])
+		c.members[len(c.members)-1].endPos = i
+	}
+	return nil
+}
+
+// skipSpaces returns position of the first non-whitespace char of data starting at i
+func skipSpaces(data []byte, i int) int {
+	for i < len(data) && asciiSpace[data[i]] == 1 {
+		i++
+	}
+	return i
+}
+
+// decodeKey returns value of quoted json string
+func decodeKey(quoted []byte) string {
+	raw := quoted[1 : len(quoted)-1]
+	if !strings.Contains(string(raw), `\`) {
+		return string(raw)
+	}
+	var key string
+	if err := json.Unmarshal(quoted, &key); err != nil {
+		panic("invalid json: " + err.Error())
+	}
+	return key
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// escapePointerToken escapes reference token of JSON Pointer as per RFC 6901
+func escapePointerToken(token string) string {
+	return pointerEscaper.Replace(token)
+}